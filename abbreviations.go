@@ -0,0 +1,166 @@
+package ns
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Abbreviations are expanded in place when typed in command position and followed by a space or <Enter>, such that the
+// expansion can be seen (and edited) before it is submitted, and is recorded in the history.  Expansions may contain
+// snippet placeholders.
+type Abbreviations struct {
+	filename string
+	items    map[string]string
+	lock     sync.Mutex
+}
+
+// NewAbbreviations creates an in-memory set of abbreviations
+func NewAbbreviations(items map[string]string) *Abbreviations {
+	a := &Abbreviations{
+		items: map[string]string{},
+	}
+	for name, expansion := range items {
+		a.items[name] = expansion
+	}
+
+	return a
+}
+
+// NewPersistedAbbreviations creates a set of abbreviations which is loaded from, and saved to the supplied file.  Each
+// line of the file contains an abbreviation followed by a space and its expansion.  A missing file is not an error.
+func NewPersistedAbbreviations(filename string) (*Abbreviations, error) {
+	a := NewAbbreviations(nil)
+	a.filename = filename
+
+	fBytes, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(fBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, expansion, found := strings.Cut(line, " ")
+		expansion = strings.TrimSpace(expansion)
+		if !found || len(expansion) == 0 {
+			return nil, fmt.Errorf("%s:%d: abbreviation \"%s\" has no expansion", filename, i+1, name)
+		}
+		a.items[name] = expansion
+	}
+
+	return a, nil
+}
+
+// Set adds or replaces an abbreviation
+func (a *Abbreviations) Set(name string, expansion string) error {
+	if len(name) == 0 || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid abbreviation \"%s\"", name)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.items[name] = expansion
+
+	return a.save()
+}
+
+// Remove removes an abbreviation
+func (a *Abbreviations) Remove(name string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.items, name)
+
+	return a.save()
+}
+
+// Get returns the expansion of an abbreviation
+func (a *Abbreviations) Get(name string) (string, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	expansion, ok := a.items[name]
+
+	return expansion, ok
+}
+
+// Names returns all abbreviations in sorted order
+func (a *Abbreviations) Names() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	names := make([]string, 0, len(a.items))
+	for name := range a.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (a *Abbreviations) save() error {
+	if a.filename == "" {
+		return nil
+	}
+
+	names := make([]string, 0, len(a.items))
+	for name := range a.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("%s %s\n", name, strings.ReplaceAll(a.items[name], "\n", " "))
+	}
+
+	return os.WriteFile(a.filename, []byte(strings.Join(lines, "")), 0644)
+}
+
+// expandAbbreviation expands the word before the cursor if it is an abbreviation in command position.  When finalize is
+// set, any snippet placeholders take their default values.  Returns true if an expansion occurred.
+func (r *Reader) expandAbbreviation(finalize bool) bool {
+	if r.config.Abbreviations == nil {
+		return false
+	}
+
+	end := r.editOffset
+	if finalize {
+		// expand the command regardless of where the cursor is
+		end = 0
+		for end < len(r.readBuffer) && r.readBuffer[end] == ' ' {
+			end++
+		}
+		for end < len(r.readBuffer) && r.readBuffer[end] != ' ' {
+			end++
+		}
+	}
+
+	start := end
+	for start > 0 && r.readBuffer[start-1] != ' ' {
+		start--
+	}
+	if start == end || len(strings.TrimSpace(string(r.readBuffer[:start]))) > 0 {
+		return false
+	}
+
+	expansion, ok := r.config.Abbreviations.Get(string(r.readBuffer[start:end]))
+	if !ok {
+		return false
+	}
+
+	if finalize {
+		text, _ := parseSnippet(expansion)
+		expansion = strings.ReplaceAll(string(text), "$", "\\$")
+	}
+
+	r.insertSnippet(start, end, expansion)
+	return true
+}
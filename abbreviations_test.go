@@ -0,0 +1,43 @@
+package ns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistedAbbreviations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "abbr")
+	a, err := NewPersistedAbbreviations(filename)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Set("gco", "git checkout"))
+	assert.NoError(t, a.Set("gc", "git commit -m \"$1\""))
+
+	a, err = NewPersistedAbbreviations(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gc", "gco"}, a.Names())
+	expansion, ok := a.Get("gco")
+	assert.True(t, ok)
+	assert.Equal(t, "git checkout", expansion)
+
+	assert.NoError(t, a.Remove("gc"))
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "gco git checkout\n", string(fBytes))
+}
+
+func TestExpandAbbreviation(t *testing.T) {
+	r := NewReader(ReaderConfig{
+		Abbreviations: NewAbbreviations(map[string]string{"gco": "git checkout"}),
+	})
+
+	r.updateBuffer("gco")
+	assert.True(t, r.expandAbbreviation(false))
+	assert.Equal(t, "git checkout", string(r.readBuffer))
+	assert.Equal(t, 12, r.editOffset)
+
+	r.updateBuffer(" gco")
+	assert.False(t, r.expandAbbreviation(false))
+}
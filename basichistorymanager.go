@@ -1,15 +1,39 @@
 package ns
 
 import (
-	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashibuto/nimble"
 )
 
+// DedupMode determines how a history manager handles repeated commands
+type DedupMode int
+
+const (
+	// DedupConsecutive ignores a command which repeats the previous command
+	DedupConsecutive DedupMode = iota
+	// DedupErase removes all earlier occurrences of a command when it is repeated, keeping only the most recent (like
+	// HISTCONTROL=erasedups)
+	DedupErase
+)
+
 type BasicHistoryManager struct {
 	index   *nimble.IndexedDequeue
+	entries []*HistoryEntry
 	maxKeep int
 	prev    string
+	// prevNamespace is the namespace of the previous command, a command is only a repeat within the same namespace
+	prevNamespace string
+	dedupMode     DedupMode
+	// usage counts the uses of each command, for ranking by frecency
+	usage       map[string]*CommandUsage
+	rankMode    RankMode
+	rankDir     string
+	rankSession string
+	retention   RetentionPolicy
+	// archivePending holds dropped entries not yet written to an archive
+	archivePending []*HistoryEntry
 }
 
 type BasicHistoryIterator struct {
@@ -38,18 +62,54 @@ func NewBasicHistoryManager(maxKeep int) *BasicHistoryManager {
 	return &BasicHistoryManager{
 		index:   nimble.NewIndexedDequeue(),
 		maxKeep: maxKeep,
+		usage:   map[string]*CommandUsage{},
 	}
 }
 
+// SetDedupMode sets how repeated commands are handled, which applies to subsequently added commands
+func (h *BasicHistoryManager) SetDedupMode(mode DedupMode) {
+	h.dedupMode = mode
+}
+
 func (h *BasicHistoryManager) Push(value string) {
-	if value == h.prev {
+	h.PushEntry(&HistoryEntry{
+		Command:   value,
+		StartedAt: time.Now(),
+	})
+}
+
+// PushEntry adds an entry to the history, unless it repeats the previous command.  Repeats still count as uses of the
+// command.
+func (h *BasicHistoryManager) PushEntry(entry *HistoryEntry) {
+	h.recordUsage(entry)
+	if h.repeatsPrevious(entry) {
 		return
 	}
-	h.prev = value
-	h.index.Push(value)
+	h.prev = entry.Command
+	h.prevNamespace = entry.Namespace
+	h.insertEntry(entry)
+}
+
+// insertEntry adds an entry to the history without treating it as the previous command, as with commands imported from
+// other sessions
+func (h *BasicHistoryManager) insertEntry(entry *HistoryEntry) {
+	if h.dedupMode == DedupErase {
+		h.removeCommand(entry.Command)
+	}
+	h.index.Push(entry.Command)
+	h.entries = append(h.entries, entry)
+	var dropped []*HistoryEntry
 	if h.index.Size() > h.maxKeep {
 		h.index.Pop()
+		dropped = append(dropped, h.entries[0])
+		h.entries = h.entries[1:]
 	}
+	h.archive(append(dropped, h.applyRetention(time.Now())...))
+}
+
+// repeatsPrevious returns true if the entry repeats the previous command in the same namespace
+func (h *BasicHistoryManager) repeatsPrevious(entry *HistoryEntry) bool {
+	return entry.Command == h.prev && entry.Namespace == h.prevNamespace
 }
 
 func (h *BasicHistoryManager) GetIterator() HistoryIterator {
@@ -76,19 +136,116 @@ func (h *BasicHistoryManager) Search(pattern string) []string {
 		return nil
 	}
 
-	// sort from most recent to oldest
-	sort.Slice(links, func(i, j int) bool {
-		return links[i].CreatedAt.After(links[j].CreatedAt)
-	})
-
-	strs := make([]string, len(links))
-	for i, link := range links {
-		strs[i] = link.Value
+	// order from most recent to oldest by the position of the entries, which doesn't depend on when each was indexed
+	matched := make(map[string]struct{}, len(links))
+	for _, link := range links {
+		matched[link.Value] = struct{}{}
+	}
+	strs := make([]string, 0, len(links))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if _, ok := matched[h.entries[i].Command]; ok {
+			strs = append(strs, h.entries[i].Command)
+		}
+	}
+	if h.rankMode == RankFrecency {
+		return h.rankFrecency(strs)
 	}
 
 	return strs
 }
 
+// removeCommand removes every occurrence of the command from the history, returning the number removed
+func (h *BasicHistoryManager) removeCommand(command string) int {
+	if len(command) == 0 {
+		return 0
+	}
+
+	removed := 0
+	for _, link := range h.index.Find(command) {
+		if link.Value == command {
+			h.index.RemoveItem(link)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+
+	entries := make([]*HistoryEntry, 0, len(h.entries)-removed)
+	for _, entry := range h.entries {
+		if entry.Command != command {
+			entries = append(entries, entry)
+		}
+	}
+	h.entries = entries
+
+	return removed
+}
+
+// Entries returns all entries, from oldest to most recent
+func (h *BasicHistoryManager) Entries() []*HistoryEntry {
+	entries := make([]*HistoryEntry, len(h.entries))
+	copy(entries, h.entries)
+
+	return entries
+}
+
+// SearchEntries returns all entries containing the pattern (case insensitive), from most recent to oldest
+func (h *BasicHistoryManager) SearchEntries(pattern string) []*HistoryEntry {
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	pattern = strings.ToLower(pattern)
+	entries := []*HistoryEntry{}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if strings.Contains(strings.ToLower(h.entries[i].Command), pattern) {
+			entries = append(entries, h.entries[i])
+		}
+	}
+
+	return entries
+}
+
 func (h *BasicHistoryManager) Exit() {
+	h.flushArchive()
+}
+
+// SliceHistoryIterator iterates over a fixed list of commands, ordered from most recent to oldest
+type SliceHistoryIterator struct {
+	commands []string
+	index    int
+}
 
+// NewSliceHistoryIterator creates an iterator over the supplied commands, ordered from most recent to oldest
+func NewSliceHistoryIterator(commands []string) *SliceHistoryIterator {
+	return &SliceHistoryIterator{
+		commands: commands,
+		index:    -1,
+	}
+}
+
+func (shi *SliceHistoryIterator) Forward() string {
+	if len(shi.commands) == 0 {
+		return ""
+	}
+
+	if shi.index > 0 {
+		shi.index--
+	}
+	if shi.index < 0 {
+		shi.index = 0
+	}
+	return shi.commands[shi.index]
+}
+
+func (shi *SliceHistoryIterator) Backward() string {
+	if len(shi.commands) == 0 {
+		return ""
+	}
+
+	if shi.index < len(shi.commands)-1 {
+		shi.index++
+	}
+	return shi.commands[shi.index]
 }
@@ -0,0 +1,41 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicHistoryDedupConsecutive(t *testing.T) {
+	h := NewBasicHistoryManager(10)
+	for _, cmd := range []string{"ls", "ls", "make", "ls"} {
+		h.Push(cmd)
+	}
+
+	assert.Equal(t, []string{"ls", "make", "ls"}, commandsOf(h.Entries()))
+}
+
+func TestBasicHistoryDedupErase(t *testing.T) {
+	h := NewBasicHistoryManager(10)
+	h.SetDedupMode(DedupErase)
+	for _, cmd := range []string{"ls", "make", "git status", "ls", "make"} {
+		h.Push(cmd)
+	}
+
+	assert.Equal(t, []string{"git status", "ls", "make"}, commandsOf(h.Entries()))
+	assert.Equal(t, []string{"make"}, h.Search("mak"))
+
+	iter := h.GetIterator()
+	assert.Equal(t, "make", iter.Backward())
+	assert.Equal(t, "ls", iter.Backward())
+	assert.Equal(t, "git status", iter.Backward())
+}
+
+func commandsOf(entries []*HistoryEntry) []string {
+	commands := make([]string, len(entries))
+	for i, entry := range entries {
+		commands[i] = entry.Command
+	}
+
+	return commands
+}
@@ -0,0 +1,108 @@
+package ns
+
+import (
+	"sort"
+	"strings"
+)
+
+// CorrectionConfig enables "did you mean" correction of unknown commands.  When the first word of a submitted line is not
+// a known command, the closest known commands by edit distance are offered, and may be accepted with a single key.
+type CorrectionConfig struct {
+	// KnownCommands returns the set of valid commands.  When nil, the completion function is queried with an empty
+	// input, and the values of the returned suggestions are used instead.
+	KnownCommands func() []string
+	// MaxCandidates limits the number of offered corrections (default 3, max 9)
+	MaxCandidates int
+	// MaxDistance is the largest edit distance considered a plausible typo (default scales with the length of the word)
+	MaxDistance int
+}
+
+// EditDistance returns the optimal string alignment distance between a and b, which is the number of insertions,
+// deletions, substitutions and transpositions of adjacent characters required to turn a into b.
+func EditDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(rb); j++ {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			best := d[i-1][j] + 1
+			if d[i][j-1]+1 < best {
+				best = d[i][j-1] + 1
+			}
+			if d[i-1][j-1]+cost < best {
+				best = d[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < best {
+				best = d[i-2][j-2] + 1
+			}
+			d[i][j] = best
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+// closestCommands returns up to maxCandidates known commands within maxDistance of word, closest first.  Nothing is
+// returned if word is itself a known command.
+func closestCommands(word string, known []string, maxCandidates int, maxDistance int) []string {
+	if maxDistance <= 0 {
+		maxDistance = (len([]rune(word)) + 2) / 3
+		if maxDistance < 1 {
+			maxDistance = 1
+		}
+	}
+
+	type candidate struct {
+		command  string
+		distance int
+	}
+
+	seen := map[string]struct{}{}
+	candidates := []candidate{}
+	for _, command := range known {
+		if command == word {
+			return nil
+		}
+		if _, ok := seen[command]; ok || len(command) == 0 {
+			continue
+		}
+		seen[command] = struct{}{}
+
+		distance := EditDistance(strings.ToLower(word), strings.ToLower(command))
+		if distance <= maxDistance {
+			candidates = append(candidates, candidate{command, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance == candidates[j].distance {
+			return candidates[i].command < candidates[j].command
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	commands := make([]string, len(candidates))
+	for i, c := range candidates {
+		commands[i] = c.command
+	}
+
+	return commands
+}
@@ -0,0 +1,39 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, EditDistance("git", "git"))
+	assert.Equal(t, 1, EditDistance("gti", "git"))
+	assert.Equal(t, 1, EditDistance("deploi", "deploy"))
+	assert.Equal(t, 3, EditDistance("", "abc"))
+	assert.Equal(t, 3, EditDistance("kitten", "sitting"))
+}
+
+func TestClosestCommands(t *testing.T) {
+	known := []string{"deploy", "delete", "describe", "get", "git"}
+	assert.Equal(t, []string{"deploy"}, closestCommands("deplyo", known, 3, 0))
+	assert.Equal(t, []string{"get", "git"}, closestCommands("gt", known, 3, 0))
+	assert.Nil(t, closestCommands("get", known, 3, 0))
+	assert.Empty(t, closestCommands("zzzzzz", known, 3, 0))
+}
+
+func TestCorrectionsSkipFc(t *testing.T) {
+	config := ReaderConfig{
+		Correction: &CorrectionConfig{KnownCommands: func() []string { return []string{"fg", "ls"} }},
+	}
+	r := NewReader(config)
+	r.windowSize = &Size{Rows: 24, Columns: 80}
+	assert.True(t, r.offerCorrections("fc 1 2"))
+
+	config.FcCommand = true
+	r = NewReader(config)
+	r.windowSize = &Size{Rows: 24, Columns: 80}
+	assert.False(t, r.offerCorrections("fc 1 2"))
+	assert.False(t, r.offerCorrections("fc -l"))
+	assert.Nil(t, r.panel)
+}
@@ -0,0 +1,277 @@
+package ns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+	"golang.org/x/sys/unix"
+)
+
+// draft is the unsubmitted content of the read buffer, saved to the draft file
+type draft struct {
+	Buffer  string    `json:"buffer"`
+	Offset  int       `json:"offset"`
+	SavedAt time.Time `json:"saved_at"`
+	// filename is the file the draft was read from
+	filename string
+}
+
+// draftWriter saves the read buffer to the draft file, at most once per interval
+type draftWriter struct {
+	filename string
+	interval time.Duration
+	lock     sync.Mutex
+	draft    draft
+	dirty    bool
+	// written is set while the draft file holds a draft written by this writer
+	written bool
+	timer   *time.Timer
+}
+
+// draftFilename returns the draft file of the process, so that concurrent sessions don't overwrite each other's drafts
+func draftFilename(base string, pid int) string {
+	return fmt.Sprintf("%s.%d", base, pid)
+}
+
+func newDraftWriter(filename string, interval time.Duration) *draftWriter {
+	return &draftWriter{
+		filename: filename,
+		interval: interval,
+	}
+}
+
+// update records the buffer and cursor offset, scheduling them to be written if they have changed
+func (w *draftWriter) update(buffer string, offset int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if buffer == w.draft.Buffer && offset == w.draft.Offset {
+		return
+	}
+	w.draft = draft{Buffer: buffer, Offset: offset, SavedAt: time.Now()}
+	w.dirty = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	}
+}
+
+// flush writes the draft if it has changed since it was last written, removing the draft file if the buffer is empty
+func (w *draftWriter) flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if !w.dirty {
+		return
+	}
+	w.dirty = false
+
+	var err error
+	if w.draft.Buffer == "" {
+		err = w.remove()
+	} else {
+		var data []byte
+		data, err = json.Marshal(w.draft)
+		if err == nil {
+			err = writeFileAtomic(w.filename, data)
+			w.written = err == nil
+		}
+	}
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// remove removes the draft file, if this writer has written it (lock must be held)
+func (w *draftWriter) remove() error {
+	if !w.written {
+		return nil
+	}
+	w.written = false
+
+	err := os.Remove(w.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// clear discards the draft, including any which is yet to be written
+func (w *draftWriter) clear() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.dirty = false
+	w.draft = draft{}
+	err := w.remove()
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// readDraft reads the draft file, returning nil if there is no draft
+func readDraft(filename string) (*draft, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	d := &draft{filename: filename}
+	err = json.Unmarshal(data, d)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if d.Buffer == "" {
+		return nil, nil
+	}
+
+	return d, nil
+}
+
+// findDraft returns the most recently saved of the drafts left by sessions which have exited, or nil if there are none.
+// The drafts of sessions which are still running are never returned.
+func findDraft(base string) (*draft, error) {
+	filenames, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var found *draft
+	for _, filename := range filenames {
+		pid, err := strconv.Atoi(strings.TrimPrefix(filename, base+"."))
+		if err != nil || pid <= 0 || processAlive(pid) {
+			continue
+		}
+		d, err := readDraft(filename)
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		if d != nil && (found == nil || d.SavedAt.After(found.SavedAt)) {
+			found = d
+		}
+	}
+
+	return found, nil
+}
+
+// processAlive returns true if the process exists, even if it belongs to another user
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// discardDraft removes the file of a draft which has been restored or declined
+func discardDraft(d *draft) {
+	err := os.Remove(d.filename)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error(err.Error())
+	}
+}
+
+// saveDraft records the buffer in the draft file, unless it holds a search or picker query.  The draft is kept to what
+// the history would record: nothing is saved while incognito or for a line the history filter ignores, and secrets are
+// redacted as they would be in the history.
+func (r *Reader) saveDraft() {
+	if r.drafts == nil || r.searchMode || r.pickerMode {
+		return
+	}
+
+	buffer := string(r.readBuffer)
+	offset := r.editOffset
+	if r.incognito {
+		buffer, offset = "", 0
+	} else if r.config.HistoryFilter != nil {
+		command, ok := r.config.HistoryFilter.Apply(buffer)
+		if !ok {
+			buffer, offset = "", 0
+		} else if command != strings.Trim(buffer, " \t\r\n") {
+			// the cursor can't be placed within redacted text, so it is moved to the end
+			buffer, offset = command, len([]rune(command))
+		}
+	}
+	r.drafts.update(buffer, offset)
+}
+
+// clearDraft discards the draft, once the command has been submitted or abandoned
+func (r *Reader) clearDraft() {
+	if r.drafts != nil {
+		r.drafts.clear()
+	}
+}
+
+// offerDraft displays the draft left by a session which has exited, if any, offering to restore it
+func (r *Reader) offerDraft() {
+	if r.drafts == nil || r.draftChecked {
+		return
+	}
+	r.draftChecked = true
+
+	d, err := findDraft(r.config.DraftFile)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	if d == nil {
+		return
+	}
+
+	preview, _ := termutils.Crop(strings.ReplaceAll(d.Buffer, "\n", " "), r.windowSize.Columns-5)
+	lines := []string{
+		fmt.Sprintf("%sRestore the unsubmitted command from %s? (y/N)%s", termutils.STYLE_BOLD, d.SavedAt.Local().Format("2006-01-02 15:04"), termutils.STYLE_RESET),
+		preview,
+	}
+	r.draftOffer = d
+	r.panel = termutils.Box(lines, r.windowSize.Columns)
+	r.requireFullRender = true
+}
+
+// answerDraft handles the key pressed in response to the draft offer, restoring the draft if accepted.  True is returned
+// if the key was consumed by the answer.
+func (r *Reader) answerDraft(input string) bool {
+	d := r.draftOffer
+	r.draftOffer = nil
+	r.panel = nil
+	r.requireFullRender = true
+
+	switch input {
+	case "y", "Y":
+		// the restored draft is saved again by this session as it is edited
+		discardDraft(d)
+		r.readBuffer = []rune(d.Buffer)
+		r.editOffset = d.Offset
+		if r.editOffset < 0 || r.editOffset > len(r.readBuffer) {
+			r.editOffset = len(r.readBuffer)
+		}
+		r.clearPlaceholders()
+		return true
+	case "n", "N", KEY_ENTER, KEY_ESCAPE:
+		discardDraft(d)
+		return true
+	}
+
+	// typing anything else declines the draft
+	discardDraft(d)
+	return false
+}
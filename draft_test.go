@@ -0,0 +1,125 @@
+package ns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDraftWriter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "draft")
+	w := newDraftWriter(filename, time.Hour)
+	w.update("kubectl get pods", 7)
+	_, err := os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	w.flush()
+	d, err := readDraft(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "kubectl get pods", d.Buffer)
+	assert.Equal(t, 7, d.Offset)
+
+	// a change scheduled before the draft is cleared is never written
+	w.update("kubectl get pods -A", 19)
+	w.clear()
+	w.flush()
+	d, err = readDraft(filename)
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+}
+
+// exitedPid is beyond the largest process id, so it never belongs to a running process
+const exitedPid = 1 << 30
+
+func TestDraftRestore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "draft")
+
+	for _, test := range []struct {
+		input    string
+		expected string
+		consumed bool
+	}{
+		{"y", "make deploy", true},
+		{"l", "", false},
+		{"n", "", true},
+	} {
+		w := newDraftWriter(draftFilename(filename, exitedPid), time.Hour)
+		w.update("make deploy", 4)
+		w.flush()
+
+		r := NewReader(ReaderConfig{DraftFile: filename})
+		r.windowSize = &Size{Rows: 24, Columns: 80}
+		r.offerDraft()
+		assert.NotNil(t, r.panel, test.input)
+		assert.Equal(t, test.consumed, r.answerDraft(test.input), test.input)
+		assert.Nil(t, r.panel)
+		assert.Equal(t, test.expected, string(r.readBuffer), test.input)
+	}
+
+	// answering discarded the draft
+	r := NewReader(ReaderConfig{DraftFile: filename})
+	r.windowSize = &Size{Rows: 24, Columns: 80}
+	r.offerDraft()
+	assert.Nil(t, r.draftOffer)
+}
+
+func TestDraftFiltered(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "draft")
+	r := NewReader(ReaderConfig{
+		DraftFile:     filename,
+		HistoryFilter: &HistoryFilter{IgnoreSpace: true, Redactions: DefaultRedactions},
+	})
+
+	for _, test := range []struct {
+		buffer    string
+		incognito bool
+		expected  string
+		offset    int
+	}{
+		{"make deploy", false, "make deploy", 4},
+		{"curl --token abc123", false, "curl --token <redacted>", 23},
+		{" mysql -psecret", false, "", 0},
+		{"make deploy", true, "", 0},
+	} {
+		r.SetIncognito(test.incognito)
+		r.readBuffer = []rune(test.buffer)
+		r.editOffset = 4
+		r.saveDraft()
+		r.drafts.flush()
+		d, err := readDraft(draftFilename(filename, os.Getpid()))
+		assert.NoError(t, err)
+		if test.expected == "" {
+			assert.Nil(t, d, test.buffer)
+			continue
+		}
+		assert.Equal(t, test.expected, d.Buffer)
+		assert.Equal(t, test.offset, d.Offset)
+	}
+}
+
+func TestDraftSessions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "draft")
+	for pid, buffer := range map[int]string{os.Getpid(): "ls", os.Getppid(): "pwd", exitedPid: "make deploy"} {
+		w := newDraftWriter(draftFilename(filename, pid), time.Hour)
+		w.update(buffer, 0)
+		w.flush()
+	}
+
+	// only the draft of the session which has exited is offered
+	d, err := findDraft(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "make deploy", d.Buffer)
+	discardDraft(d)
+	d, err = findDraft(filename)
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	// a writer only removes the draft it has written
+	w := newDraftWriter(draftFilename(filename, os.Getppid()), time.Hour)
+	w.clear()
+	_, err = os.Stat(draftFilename(filename, os.Getppid()))
+	assert.NoError(t, err)
+}
@@ -12,6 +12,9 @@ var suggestions = []*ns.Suggestion{
 	{
 		Value:   "carrot",
 		Display: "carrot (is an orange vegetable)",
+		Preview: func() string {
+			return "A root vegetable, usually orange in color.\nRich in beta-carotene.\nCan be eaten raw or cooked."
+		},
 	},
 	{
 		Value:   "cucumber",
@@ -80,7 +83,15 @@ func main() {
 			return nil
 		},
 		CompletionFunction: completer,
-		HistoryManager:     ns.NewPersistedHistoryManager(10, "/tmp/example.hist"),
+		HelpFunction: func(beforeCursor, afterCursor, full string) string {
+			fields := strings.Fields(full)
+			if len(fields) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%s\n\nNo manual entry, but this is where it would go.", fields[0])
+		},
+		HistoryManager: ns.NewPersistedHistoryManager(10, "/tmp/example.hist"),
+		Correction:     &ns.CorrectionConfig{},
 		PromptFunction: func() string {
 			return "$ "
 			//return fmt.Sprintf("%s$%s ", termutils.CreateFgColor(0, 255, 255), termutils.STYLE_RESET)
@@ -0,0 +1,138 @@
+package ns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseFcCommand returns the arguments of an fc command, and false if the line isn't one
+func parseFcCommand(line string) ([]string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "fc" || len(fields) > 3 {
+		return nil, false
+	}
+
+	return fields[1:], true
+}
+
+// SelectFcCommands selects the commands named by the arguments of an fc command, with the history ordered from oldest to
+// most recent.  Each argument is a history number (as with !n), a negative offset from the end of the history, or the
+// prefix of the most recent command beginning with it.  With no arguments the previous command is selected, with one
+// argument the command it names, and with two the range between them, which is reversed if the first is more recent.
+func SelectFcCommands(args []string, history []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"-1"}
+	}
+
+	first, err := findFcEvent(args[0], history)
+	if err != nil {
+		return nil, err
+	}
+	last := first
+	if len(args) > 1 {
+		last, err = findFcEvent(args[1], history)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commands := []string{}
+	if first <= last {
+		for i := first; i <= last; i++ {
+			commands = append(commands, history[i])
+		}
+	} else {
+		for i := first; i >= last; i-- {
+			commands = append(commands, history[i])
+		}
+	}
+
+	return commands, nil
+}
+
+// findFcEvent returns the position in the history of the command named by an fc argument
+func findFcEvent(arg string, history []string) (int, error) {
+	if n, err := strconv.Atoi(arg); err == nil {
+		index := n - 1
+		if n < 0 {
+			index = len(history) + n
+		}
+		if index < 0 || index >= len(history) {
+			return 0, fmt.Errorf("%s: %w", arg, ErrHistoryEventNotFound)
+		}
+		return index, nil
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if strings.HasPrefix(history[i], arg) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s: %w", arg, ErrHistoryEventNotFound)
+}
+
+// runFc opens the commands selected by the fc arguments in the editor, and runs each line of the saved result.  Nothing is
+// run if the result is empty.
+func (r *Reader) runFc(args []string) error {
+	commands, err := SelectFcCommands(args, r.historyCommands())
+	if err != nil {
+		r.config.OnError(fmt.Errorf("fc: %w", err))
+		return nil
+	}
+
+	text, err := editText(strings.Join(commands, "\n"))
+	if err != nil {
+		r.config.OnError(fmt.Errorf("fc: %w", err))
+		return nil
+	}
+
+	return r.runLines(text)
+}
+
+// runLines runs each non-blank line of text edited in the editor as a command, showing it as it is run
+func (r *Reader) runLines(text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		// show each command as it is run
+		fmt.Fprintln(r.output, line)
+		err := r.runCommand(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startEditPicker enters the history picker, to edit the selected command and then run each line of the result, as fc
+// does
+func (r *Reader) startEditPicker() {
+	r.startPicker()
+	r.pickerEdit = true
+}
+
+// editPickerEntry leaves the picker and opens the selected command in the editor, returning the result to be run by
+// runLines.  An empty string is returned if nothing should be run, either because no command was selected or the result
+// was empty.
+func (r *Reader) editPickerEntry() (string, error) {
+	entry := r.selectedPickerEntry()
+	r.exitPicker(false)
+	if entry == nil {
+		return "", nil
+	}
+
+	text, err := editText(entry.Command)
+	if err != nil {
+		return "", err
+	}
+	r.requireFullRender = true
+	if len(strings.TrimSpace(text)) == 0 {
+		return "", nil
+	}
+
+	return text, nil
+}
@@ -0,0 +1,103 @@
+package ns
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFcCommands(t *testing.T) {
+	history := []string{"make", "git status", "git log", "ls"}
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, []string{"ls"}},
+		{[]string{"2"}, []string{"git status"}},
+		{[]string{"-2"}, []string{"git log"}},
+		{[]string{"git"}, []string{"git log"}},
+		{[]string{"2", "3"}, []string{"git status", "git log"}},
+		{[]string{"-1", "git"}, []string{"ls", "git log"}},
+	}
+	for _, test := range tests {
+		commands, err := SelectFcCommands(test.args, history)
+		assert.NoError(t, err, test.args)
+		assert.Equal(t, test.expected, commands, test.args)
+	}
+
+	_, err := SelectFcCommands([]string{"9"}, history)
+	assert.True(t, errors.Is(err, ErrHistoryEventNotFound))
+	_, err = SelectFcCommands([]string{"rm"}, history)
+	assert.True(t, errors.Is(err, ErrHistoryEventNotFound))
+}
+
+func TestRunFc(t *testing.T) {
+	// an editor which saves the commands unchanged
+	t.Setenv("EDITOR", "true")
+
+	processed := []string{}
+	errs := []error{}
+	hm := NewBasicHistoryManager(10)
+	r := NewReader(ReaderConfig{
+		HistoryManager: hm,
+		FcCommand:      true,
+		ProcessFunction: func(s string) error {
+			processed = append(processed, s)
+			return nil
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	var output bytes.Buffer
+	r.output = &output
+	hm.Push("make")
+	hm.Push("make test")
+	hm.Push("ls")
+
+	args, ok := parseFcCommand("fc 1 2")
+	assert.True(t, ok)
+	assert.NoError(t, r.runFc(args))
+	assert.Equal(t, []string{"make", "make test"}, processed)
+	assert.Equal(t, []string{"make", "make test", "ls", "make", "make test"}, commandsOf(hm.Entries()))
+	assert.Equal(t, "make\nmake test\n", output.String())
+
+	// errors are reported without ending the read loop
+	assert.NoError(t, r.runFc([]string{"9"}))
+	assert.Equal(t, 1, len(errs))
+	assert.True(t, errors.Is(errs[0], ErrHistoryEventNotFound))
+
+	_, ok = parseFcCommand("fcat file")
+	assert.False(t, ok)
+}
+
+func TestEditPickerEntry(t *testing.T) {
+	t.Setenv("EDITOR", "true")
+
+	processed := []string{}
+	hm := NewBasicHistoryManager(10)
+	r := NewReader(ReaderConfig{
+		HistoryManager: hm,
+		ProcessFunction: func(s string) error {
+			processed = append(processed, s)
+			return nil
+		},
+	})
+	r.output = &bytes.Buffer{}
+	hm.Push("make\nmake test")
+	hm.Push("ls")
+
+	r.startEditPicker()
+	r.updatePicker()
+	r.movePicker(1)
+	text, err := r.editPickerEntry()
+	assert.NoError(t, err)
+	assert.False(t, r.pickerMode)
+	assert.Equal(t, "make\nmake test", text)
+
+	// each line of the result is run and recorded separately, as with fc
+	assert.NoError(t, r.runLines(text+"\n\n"))
+	assert.Equal(t, []string{"make", "make test"}, processed)
+	assert.Equal(t, []string{"make\nmake test", "ls", "make", "make test"}, commandsOf(hm.Entries()))
+}
@@ -2,13 +2,18 @@ package ns
 
 import (
 	"os"
+	"sync"
 
 	"golang.org/x/sys/unix"
 )
 
+// FileLock is an exclusive lock held on a file with flock, which excludes other processes.  Goroutines of the same
+// process sharing a FileLock are excluded from each other as well.
 type FileLock struct {
 	filename string
-	f        *os.File
+	// lock excludes the goroutines of this process, since flock is held per open file rather than per goroutine
+	lock sync.Mutex
+	f    *os.File
 }
 
 func NewFileLock(filename string) *FileLock {
@@ -18,25 +23,32 @@ func NewFileLock(filename string) *FileLock {
 }
 
 func (fl *FileLock) Lock() error {
+	fl.lock.Lock()
+
 	f, err := os.OpenFile(fl.filename, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
+		fl.lock.Unlock()
 		return err
 	}
 
-	fl.f = f
-
-	err = unix.Flock(int(fl.f.Fd()), unix.LOCK_EX)
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
 	if err != nil {
+		f.Close()
+		fl.lock.Unlock()
 		return err
 	}
+	fl.f = f
 
 	return nil
 }
 
 func (fl *FileLock) Unlock() error {
-	defer fl.f.Close()
+	f := fl.f
+	fl.f = nil
+	defer fl.lock.Unlock()
+	defer f.Close()
 
-	err := unix.Flock(int(fl.f.Fd()), unix.LOCK_UN)
+	err := unix.Flock(int(f.Fd()), unix.LOCK_UN)
 	if err != nil {
 		return err
 	}
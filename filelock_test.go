@@ -1,6 +1,8 @@
 package ns
 
 import (
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,7 +28,7 @@ func TestFileLockConcur(t *testing.T) {
 	assert.NoError(t, err)
 	go func() {
 		time.Sleep(1 * time.Second)
-		err = l.Unlock()
+		err := l.Unlock()
 		assert.NoError(t, err)
 	}()
 
@@ -36,3 +38,22 @@ func TestFileLockConcur(t *testing.T) {
 	err = l2.Unlock()
 	assert.NoError(t, err)
 }
+
+func TestFileLockGoroutines(t *testing.T) {
+	l := NewFileLock(filepath.Join(t.TempDir(), "test.lock"))
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, l.Lock())
+				counter++
+				assert.NoError(t, l.Unlock())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 400, counter)
+}
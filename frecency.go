@@ -0,0 +1,225 @@
+package ns
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// RankMode determines the order of history search results
+type RankMode int
+
+const (
+	// RankRecent orders search results from most recent to oldest
+	RankRecent RankMode = iota
+	// RankFrecency orders search results by a score combining how often and how recently each command was used, boosted
+	// for commands used in the current working directory or session (see SetRankContext)
+	RankFrecency
+)
+
+// rankContextSetter is implemented by history managers which rank search results by the context of the search
+type rankContextSetter interface {
+	SetRankContext(workingDir string, sessionID string)
+}
+
+// CommandUsage counts the uses of a command
+type CommandUsage struct {
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// frecencyScore weights the use count by how recently the command was last used
+func frecencyScore(usage CommandUsage, now time.Time) float64 {
+	age := now.Sub(usage.LastUsed)
+	weight := 0.25
+	switch {
+	case age < time.Hour:
+		weight = 4
+	case age < 24*time.Hour:
+		weight = 2
+	case age < 7*24*time.Hour:
+		weight = 0.5
+	}
+
+	return float64(usage.Count) * weight
+}
+
+// SetRankMode sets the order of search results
+func (h *BasicHistoryManager) SetRankMode(mode RankMode) {
+	h.rankMode = mode
+}
+
+// SetRankContext sets the working directory and session in which the history is searched.  When ranking by frecency,
+// commands previously used in either are boosted.  The reader sets these before each search.
+func (h *BasicHistoryManager) SetRankContext(workingDir string, sessionID string) {
+	h.rankDir = workingDir
+	h.rankSession = sessionID
+}
+
+// Usage returns the use count of a command, including repeated uses which aren't added to the history
+func (h *BasicHistoryManager) Usage(command string) CommandUsage {
+	if usage, ok := h.usage[command]; ok {
+		return *usage
+	}
+
+	return CommandUsage{}
+}
+
+// recordUsage counts a use of the entry's command
+func (h *BasicHistoryManager) recordUsage(entry *HistoryEntry) {
+	usage, ok := h.usage[entry.Command]
+	if !ok {
+		usage = &CommandUsage{}
+		h.usage[entry.Command] = usage
+	}
+	usage.Count++
+	if entry.StartedAt.After(usage.LastUsed) {
+		usage.LastUsed = entry.StartedAt
+	}
+
+	if len(h.usage) > 2*h.maxKeep {
+		pruneUsage(h.usage, h.maxKeep)
+	}
+}
+
+// pruneUsage removes all but the most recently used commands, up to the number to keep
+func pruneUsage(usage map[string]*CommandUsage, maxKeep int) {
+	if len(usage) <= maxKeep {
+		return
+	}
+
+	commands := make([]string, 0, len(usage))
+	for command := range usage {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return usage[commands[i]].LastUsed.After(usage[commands[j]].LastUsed)
+	})
+	for _, command := range commands[maxKeep:] {
+		delete(usage, command)
+	}
+}
+
+// rankFrecency orders the distinct commands by frecency score, the most recent first among equal scores
+func (h *BasicHistoryManager) rankFrecency(commands []string) []string {
+	now := time.Now()
+	scores := map[string]float64{}
+	recency := map[string]int{}
+	sameDir := map[string]bool{}
+	sameSession := map[string]bool{}
+	for _, command := range commands {
+		scores[command] = frecencyScore(h.Usage(command), now)
+	}
+	for i, entry := range h.entries {
+		if _, ok := scores[entry.Command]; !ok {
+			continue
+		}
+		recency[entry.Command] = i
+		sameDir[entry.Command] = sameDir[entry.Command] || (h.rankDir != "" && entry.WorkingDir == h.rankDir)
+		sameSession[entry.Command] = sameSession[entry.Command] || (h.rankSession != "" && entry.SessionID == h.rankSession)
+	}
+	for command := range scores {
+		if sameDir[command] {
+			scores[command] *= 2
+		}
+		if sameSession[command] {
+			scores[command] *= 1.5
+		}
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for command := range scores {
+		ranked = append(ranked, command)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return recency[ranked[i]] > recency[ranked[j]]
+	})
+
+	return ranked
+}
+
+// usageFilename is the file in which a persisted history keeps its command use counts
+func (pm *PersistedHistoryManager) usageFilename() string {
+	return pm.filename + ".usage"
+}
+
+// readUsage reads the use counts of the persisted history.  The file lock must be held.
+func (pm *PersistedHistoryManager) readUsage() (map[string]*CommandUsage, error) {
+	data, err := os.ReadFile(pm.usageFilename())
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := pm.codec.open(string(data))
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]*CommandUsage{}
+	err = json.Unmarshal(plaintext, &usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// writeUsage adds the pending use counts to those in the usage file, keeping the most recently used commands up to the
+// number of entries to keep.  The file lock must be held.
+func (pm *PersistedHistoryManager) writeUsage(pending map[string]*CommandUsage) error {
+	usage, err := pm.readUsage()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		usage = map[string]*CommandUsage{}
+	}
+
+	for command, delta := range pending {
+		existing, ok := usage[command]
+		if !ok {
+			existing = &CommandUsage{}
+			usage[command] = existing
+		}
+		existing.Count += delta.Count
+		if delta.LastUsed.After(existing.LastUsed) {
+			existing.LastUsed = delta.LastUsed
+		}
+	}
+
+	return pm.saveUsage(usage)
+}
+
+// addPendingUsage counts a use of the entry's command, to be added to the usage file.  The flush lock must be held.
+func (pm *PersistedHistoryManager) addPendingUsage(entry *HistoryEntry) {
+	usage, ok := pm.pendingUsage[entry.Command]
+	if !ok {
+		usage = &CommandUsage{}
+		pm.pendingUsage[entry.Command] = usage
+	}
+	usage.Count++
+	if entry.StartedAt.After(usage.LastUsed) {
+		usage.LastUsed = entry.StartedAt
+	}
+}
+
+// saveUsage replaces the usage file with the use counts of the most recently used commands, up to the number of entries
+// to keep.  The file lock must be held.
+func (pm *PersistedHistoryManager) saveUsage(usage map[string]*CommandUsage) error {
+	pruneUsage(usage, pm.config.MaxKeep)
+
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := pm.codec.seal(data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(pm.usageFilename(), []byte(sealed+"\n"))
+}
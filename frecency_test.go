@@ -0,0 +1,60 @@
+package ns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrecencyRanking(t *testing.T) {
+	now := time.Now()
+	h := NewBasicHistoryManager(100)
+	h.SetRankMode(RankFrecency)
+	h.PushEntry(&HistoryEntry{Command: "git log", StartedAt: now.Add(-30 * 24 * time.Hour), WorkingDir: "/srv"})
+	h.PushEntry(&HistoryEntry{Command: "git status", StartedAt: now.Add(-3 * time.Minute)})
+	h.PushEntry(&HistoryEntry{Command: "git status", StartedAt: now.Add(-2 * time.Minute)})
+	h.PushEntry(&HistoryEntry{Command: "git status", StartedAt: now.Add(-time.Minute)})
+	h.PushEntry(&HistoryEntry{Command: "git commit", StartedAt: now.Add(-10 * 24 * time.Hour)})
+
+	assert.Equal(t, 3, h.Usage("git status").Count)
+	assert.Equal(t, []string{"git status", "git commit", "git log"}, h.Search("git"))
+
+	h.SetRankMode(RankRecent)
+	assert.Equal(t, "git commit", h.Search("git")[0])
+
+	// commands used in the same working directory are boosted
+	h.SetRankMode(RankFrecency)
+	h.SetRankContext("/srv", "")
+	assert.Equal(t, []string{"git status", "git log", "git commit"}, h.Search("git"))
+}
+
+func TestPersistedFrecency(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{MaxKeep: 10, Filename: filename, RankMode: RankFrecency}
+
+	pm := NewPersistedHistoryManagerWithConfig(config)
+	pm.Push("make test")
+	pm.Push("make test")
+	pm.Push("make test")
+	pm.Push("make build")
+	pm.Exit()
+
+	pm = NewPersistedHistoryManagerWithConfig(config)
+	defer pm.Exit()
+	assert.Equal(t, 3, pm.Usage("make test").Count)
+	assert.Equal(t, []string{"make test", "make build"}, pm.Search("make"))
+}
+
+func TestPersistedRecentRankingKeepsNoUsage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManager(10, filename)
+	pm.Push("make test")
+	pm.Push("make test")
+	pm.Exit()
+
+	_, err := os.Stat(filename + ".usage")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,53 @@
+package ns
+
+import (
+	"unicode"
+)
+
+// FuzzyMatch determines whether all characters of pattern occur in text, in order (case insensitive).  Returned are a
+// score, where higher is a better match, and the rune offsets of the matched characters in text.  Consecutive matches and
+// matches at the start of words score higher.
+func FuzzyMatch(pattern string, text string) (int, []int, bool) {
+	patternRunes := []rune(pattern)
+	if len(patternRunes) == 0 {
+		return 0, nil, true
+	}
+
+	textRunes := []rune(text)
+	positions := make([]int, 0, len(patternRunes))
+	score := 0
+	p := 0
+	prevMatch := -2
+	for i, ch := range textRunes {
+		if p == len(patternRunes) {
+			break
+		}
+		if unicode.ToLower(ch) != unicode.ToLower(patternRunes[p]) {
+			continue
+		}
+
+		score += 1
+		if prevMatch == i-1 {
+			score += 5
+		}
+		if i == 0 || isWordBoundary(textRunes[i-1]) {
+			score += 3
+		}
+		positions = append(positions, i)
+		prevMatch = i
+		p++
+	}
+
+	if p < len(patternRunes) {
+		return 0, nil, false
+	}
+
+	// prefer matches which are tightly grouped
+	score -= (positions[len(positions)-1] - positions[0] + 1 - len(positions)) / 2
+
+	return score, positions, true
+}
+
+func isWordBoundary(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,26 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	score, positions, ok := FuzzyMatch("gco", "git checkout main")
+	assert.True(t, ok)
+	assert.Equal(t, []int{0, 4, 9}, positions)
+	assert.Greater(t, score, 0)
+
+	_, _, ok = FuzzyMatch("xyz", "git checkout main")
+	assert.False(t, ok)
+
+	_, _, ok = FuzzyMatch("", "anything")
+	assert.True(t, ok)
+}
+
+func TestFuzzyMatchRanking(t *testing.T) {
+	tight, _, _ := FuzzyMatch("dep", "deploy --env prod")
+	loose, _, _ := FuzzyMatch("dep", "describe pod")
+	assert.Greater(t, tight, loose)
+}
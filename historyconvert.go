@@ -0,0 +1,232 @@
+package ns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadBashHistory parses a bash history file.  Timestamps recorded by bash when HISTTIMEFORMAT is set (comment lines of
+// the form #1700000000 preceding a command) are preserved.
+func ReadBashHistory(r io.Reader) ([]*HistoryEntry, error) {
+	entries := []*HistoryEntry{}
+	var startedAt time.Time
+
+	scanner := newHistoryScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "#") {
+			if seconds, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				startedAt = time.Unix(seconds, 0)
+				continue
+			}
+		}
+
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entries = append(entries, &HistoryEntry{
+			Command:   line,
+			StartedAt: startedAt,
+		})
+		startedAt = time.Time{}
+	}
+
+	return entries, scanner.Err()
+}
+
+// WriteBashHistory writes entries in the bash history format, preceding each command with its timestamp where known
+func WriteBashHistory(w io.Writer, entries []*HistoryEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		if !entry.StartedAt.IsZero() {
+			fmt.Fprintf(bw, "#%d\n", entry.StartedAt.Unix())
+		}
+		// bash history has no representation of multi-line commands without timestamps, so they are joined
+		fmt.Fprintf(bw, "%s\n", strings.ReplaceAll(entry.Command, "\n", " "))
+	}
+
+	return bw.Flush()
+}
+
+// ReadZshHistory parses a zsh history file, in either the plain or extended (": <start>:<elapsed>;<command>") format.
+// Multi-line commands, which zsh continues with a trailing backslash, are joined.
+func ReadZshHistory(r io.Reader) ([]*HistoryEntry, error) {
+	entries := []*HistoryEntry{}
+	var current *HistoryEntry
+
+	scanner := newHistoryScanner(r)
+	for scanner.Scan() {
+		line := unmetafyZsh(strings.TrimRight(scanner.Text(), "\r"))
+
+		if current == nil {
+			current = &HistoryEntry{}
+			if strings.HasPrefix(line, ": ") {
+				if meta, command, found := strings.Cut(line[2:], ";"); found {
+					start, elapsed, _ := strings.Cut(meta, ":")
+					if seconds, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64); err == nil {
+						// WriteZshHistory records an unknown start time as 0
+						if seconds != 0 {
+							current.StartedAt = time.Unix(seconds, 0)
+						}
+						if duration, err := strconv.ParseInt(strings.TrimSpace(elapsed), 10, 64); err == nil {
+							current.Duration = time.Duration(duration) * time.Second
+						}
+						line = command
+					}
+				}
+			}
+		} else {
+			current.Command += "\n"
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current.Command += line[:len(line)-1]
+			continue
+		}
+
+		current.Command += line
+		if len(strings.TrimSpace(current.Command)) > 0 {
+			entries = append(entries, current)
+		}
+		current = nil
+	}
+
+	if current != nil && len(strings.TrimSpace(current.Command)) > 0 {
+		entries = append(entries, current)
+	}
+
+	return entries, scanner.Err()
+}
+
+// WriteZshHistory writes entries in the zsh extended history format
+func WriteZshHistory(w io.Writer, entries []*HistoryEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		startedAt := entry.StartedAt
+		if startedAt.IsZero() {
+			startedAt = time.Unix(0, 0)
+		}
+		command := strings.ReplaceAll(entry.Command, "\n", "\\\n")
+		fmt.Fprintf(bw, ": %d:%d;%s\n", startedAt.Unix(), int64(entry.Duration/time.Second), command)
+	}
+
+	return bw.Flush()
+}
+
+// unmetafyZsh decodes the escaping zsh applies to bytes with special meaning in its history file
+func unmetafyZsh(line string) string {
+	const meta = 0x83
+	if strings.IndexByte(line, meta) < 0 {
+		return line
+	}
+
+	b := []byte(line)
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == meta && i+1 < len(b) {
+			i++
+			out = append(out, b[i]^32)
+			continue
+		}
+		out = append(out, b[i])
+	}
+
+	return string(out)
+}
+
+// ReadFishHistory parses a fish history file, preserving the time at which each command was run
+func ReadFishHistory(r io.Reader) ([]*HistoryEntry, error) {
+	entries := []*HistoryEntry{}
+	var current *HistoryEntry
+
+	scanner := newHistoryScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "- cmd: ") {
+			current = &HistoryEntry{Command: unescapeFish(line[len("- cmd: "):])}
+			entries = append(entries, current)
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "when: ") {
+			if seconds, err := strconv.ParseInt(strings.TrimSpace(trimmed[len("when: "):]), 10, 64); err == nil {
+				current.StartedAt = time.Unix(seconds, 0)
+			}
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// WriteFishHistory writes entries in the fish history format
+func WriteFishHistory(w io.Writer, entries []*HistoryEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		fmt.Fprintf(bw, "- cmd: %s\n", escapeFish(entry.Command))
+		if !entry.StartedAt.IsZero() {
+			fmt.Fprintf(bw, "  when: %d\n", entry.StartedAt.Unix())
+		}
+	}
+
+	return bw.Flush()
+}
+
+func escapeFish(command string) string {
+	command = strings.ReplaceAll(command, "\\", "\\\\")
+	return strings.ReplaceAll(command, "\n", "\\n")
+}
+
+func unescapeFish(command string) string {
+	var sb strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] == '\\' && i+1 < len(command) {
+			switch command[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				sb.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(command[i])
+	}
+
+	return sb.String()
+}
+
+func newHistoryScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
+
+// ImportHistory pushes the supplied entries, ordered from oldest to most recent, into the history manager
+func ImportHistory(hm HistoryManager, entries []*HistoryEntry) {
+	ehm, hasEntries := hm.(EntryHistoryManager)
+	for _, entry := range entries {
+		if hasEntries {
+			ehm.PushEntry(entry)
+		} else {
+			hm.Push(entry.Command)
+		}
+	}
+}
+
+// ExportHistory returns the entries of the history manager, ordered from oldest to most recent
+func ExportHistory(hm HistoryManager) []*HistoryEntry {
+	return historyEntries(hm)
+}
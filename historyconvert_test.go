@@ -0,0 +1,74 @@
+package ns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadBashHistory(t *testing.T) {
+	entries, err := ReadBashHistory(strings.NewReader("ls\n#1700000000\ngit status\n\nmake\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.True(t, entries[0].StartedAt.IsZero())
+	assert.Equal(t, "git status", entries[1].Command)
+	assert.Equal(t, int64(1700000000), entries[1].StartedAt.Unix())
+	assert.True(t, entries[2].StartedAt.IsZero())
+}
+
+func TestReadZshHistory(t *testing.T) {
+	history := ": 1700000000:3;make test\nplain\n: 1700000100:0;for i in 1 2; do\\\necho $i\\\ndone\n"
+	entries, err := ReadZshHistory(strings.NewReader(history))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "make test", entries[0].Command)
+	assert.Equal(t, 3*time.Second, entries[0].Duration)
+	assert.Equal(t, "plain", entries[1].Command)
+	assert.Equal(t, "for i in 1 2; do\necho $i\ndone", entries[2].Command)
+	assert.Equal(t, int64(1700000100), entries[2].StartedAt.Unix())
+}
+
+func TestReadFishHistory(t *testing.T) {
+	history := "- cmd: ls -la\n  when: 1700000000\n- cmd: echo a\\\\b\\nc\n  when: 1700000001\n  paths:\n    - a\\\\b\n"
+	entries, err := ReadFishHistory(strings.NewReader(history))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "ls -la", entries[0].Command)
+	assert.Equal(t, int64(1700000000), entries[0].StartedAt.Unix())
+	assert.Equal(t, "echo a\\b\nc", entries[1].Command)
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	entries := []*HistoryEntry{
+		{Command: "ls", StartedAt: time.Unix(1700000000, 0)},
+		{Command: "echo 'a\nb'", StartedAt: time.Unix(1700000001, 0), Duration: 2 * time.Second},
+		{Command: "pwd"},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteZshHistory(&buf, entries))
+	zsh, err := ReadZshHistory(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, entries, zsh)
+
+	buf.Reset()
+	assert.NoError(t, WriteFishHistory(&buf, entries))
+	fish, err := ReadFishHistory(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, entries[1].Command, fish[1].Command)
+	assert.Equal(t, entries[1].StartedAt, fish[1].StartedAt)
+
+	buf.Reset()
+	assert.NoError(t, WriteBashHistory(&buf, entries))
+	bash, err := ReadBashHistory(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "echo 'a b'", bash[1].Command)
+	assert.Equal(t, entries[1].StartedAt, bash[1].StartedAt)
+
+	hm := NewBasicHistoryManager(10)
+	ImportHistory(hm, entries)
+	assert.Equal(t, entries, ExportHistory(hm))
+}
@@ -0,0 +1,380 @@
+package ns
+
+import (
+	"os"
+	"strings"
+
+	"github.com/hashibuto/nimble"
+)
+
+// Delete removes every occurrence of the command from the history and its archives, along with its use count
+func (h *BasicHistoryManager) Delete(command string) int {
+	removed := h.removeCommand(command)
+	h.editArchives(command, "")
+	delete(h.usage, command)
+	if h.prev == command {
+		h.prev = ""
+	}
+
+	return removed
+}
+
+// Replace replaces every occurrence of the command in the history and its archives, moving its use count to the
+// replacement
+func (h *BasicHistoryManager) Replace(command string, replacement string) int {
+	if len(command) == 0 || len(replacement) == 0 || command == replacement {
+		return 0
+	}
+	h.editArchives(command, replacement)
+
+	replaced := 0
+	for _, entry := range h.entries {
+		if entry.Command == command {
+			entry.Command = replacement
+			replaced++
+		}
+	}
+	if replaced == 0 {
+		return 0
+	}
+
+	// the index holds the commands themselves, and links can't be re-keyed in place, so it is rebuilt in the order of the
+	// entries.  Search orders its results by the position of the entries, so the recency of each is unchanged.
+	h.index = nimble.NewIndexedDequeue()
+	for _, entry := range h.entries {
+		h.index.Push(entry.Command)
+	}
+	replaceUsage(h.usage, command, replacement)
+	if h.prev == command {
+		h.prev = replacement
+	}
+
+	return replaced
+}
+
+// replaceUsage moves the use count of the command to the replacement, or removes it if there is no replacement
+func replaceUsage(usage map[string]*CommandUsage, command string, replacement string) bool {
+	existing, ok := usage[command]
+	if !ok {
+		return false
+	}
+
+	delete(usage, command)
+	if replacement == "" {
+		return true
+	}
+	if target, ok := usage[replacement]; ok {
+		target.Count += existing.Count
+		if existing.LastUsed.After(target.LastUsed) {
+			target.LastUsed = existing.LastUsed
+		}
+	} else {
+		usage[replacement] = existing
+	}
+
+	return true
+}
+
+// Delete removes every occurrence of the command from the history, and schedules the history file to be rewritten without
+// it.  Nothing is removed from a history file which couldn't be loaded.
+func (pm *PersistedHistoryManager) Delete(command string) int {
+	return pm.edit(command, "")
+}
+
+// Replace replaces every occurrence of the command in the history, and schedules the history file to be rewritten with the
+// replacement.  Nothing is replaced in a history file which couldn't be loaded.
+func (pm *PersistedHistoryManager) Replace(command string, replacement string) int {
+	if len(replacement) == 0 {
+		return 0
+	}
+
+	return pm.edit(command, replacement)
+}
+
+// historyEdit is a replacement of a command in the history file, or its deletion when there is no replacement
+type historyEdit struct {
+	command     string
+	replacement string
+}
+
+// edit replaces the command in memory, or deletes it when there is no replacement, and schedules the same edit of the
+// history file.  The file is rewritten by the flush thread rather than tombstoned, so that nothing of a deleted command
+// remains in it, and the edit is retried until the rewrite succeeds.
+func (pm *PersistedHistoryManager) edit(command string, replacement string) int {
+	if len(command) == 0 || command == replacement || pm.readOnly.Load() {
+		// the file of a read only history is never rewritten, the command would reappear when it is next loaded
+		return 0
+	}
+
+	pm.mergeShared()
+	pm.flushLock.Lock()
+	var count int
+	if replacement == "" {
+		count = pm.BasicHistoryManager.Delete(command)
+	} else {
+		count = pm.BasicHistoryManager.Replace(command, replacement)
+	}
+	if replacement == "" {
+		unwritten := []*HistoryEntry{}
+		for _, entry := range pm.unwritten {
+			if entry.Command != command {
+				unwritten = append(unwritten, entry)
+			}
+		}
+		pm.unwritten = unwritten
+	}
+	replaceUsage(pm.pendingUsage, command, replacement)
+	// the file may hold occurrences which are no longer in memory, so the edit is made even if none were found
+	pm.pendingEdits = append(pm.pendingEdits, historyEdit{command: command, replacement: replacement})
+	pm.flushLock.Unlock()
+
+	select {
+	case pm.flushChan <- struct{}{}:
+	default:
+	}
+
+	return count
+}
+
+// applyEdits rewrites the history file, archives and usage file with the commands replaced, or removed when there is no
+// replacement.  The files are only rewritten if they contain the commands.  The file lock must be held.
+func (pm *PersistedHistoryManager) applyEdits(edits []historyEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+
+	if pm.config.ShareHistory {
+		// pick up anything appended by other processes, before the rewrite moves the offset past it
+		err := pm.readShared()
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := pm.readHistoryFile()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if file != nil {
+		entries, changed := editHistoryEntries(file.entries, edits)
+		if changed {
+			if pm.config.ShareHistory {
+				// replaced entries aren't to be imported as commands from other processes
+				pm.flushLock.Lock()
+				for _, entry := range entries {
+					pm.known[historyEntryKey(entry)] = struct{}{}
+				}
+				pm.flushLock.Unlock()
+			}
+			err = pm.keepCorrupt(file)
+			if err != nil {
+				return err
+			}
+			err = pm.writeHistoryFile(entries)
+			if err != nil {
+				return err
+			}
+			pm.updateFileOffset()
+		}
+	}
+
+	if pm.config.Retention.ArchiveDir != "" {
+		err = rewriteHistoryArchives(pm.config.Retention.ArchiveDir, pm.archiveCodecs(), pm.codec, false, func(entries []*HistoryEntry) ([]*HistoryEntry, bool) {
+			return editHistoryEntries(entries, edits)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	usage, err := pm.readUsage()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	changed := false
+	for _, edit := range edits {
+		changed = replaceUsage(usage, edit.command, edit.replacement) || changed
+	}
+	if changed {
+		return pm.saveUsage(usage)
+	}
+
+	return nil
+}
+
+// Delete removes every occurrence of the command, rewriting the segments which contain it
+func (m *SegmentHistoryManager) Delete(command string) int {
+	return m.edit(command, "")
+}
+
+// Replace replaces every occurrence of the command, rewriting the segments which contain it
+func (m *SegmentHistoryManager) Replace(command string, replacement string) int {
+	if len(replacement) == 0 {
+		return 0
+	}
+
+	return m.edit(command, replacement)
+}
+
+func (m *SegmentHistoryManager) edit(command string, replacement string) int {
+	if len(command) == 0 || command == replacement {
+		return 0
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	apply := func(entries []*HistoryEntry) ([]*HistoryEntry, int) {
+		edited := []*HistoryEntry{}
+		count := 0
+		for _, entry := range entries {
+			if entry.Command != command {
+				edited = append(edited, entry)
+				continue
+			}
+			count++
+			if replacement != "" {
+				entry.Command = replacement
+				edited = append(edited, entry)
+			}
+		}
+		return edited, count
+	}
+
+	total := 0
+	pattern := strings.ToLower(command)
+	for _, segment := range m.sealed {
+		idx, err := m.segmentIndex(segment)
+		if err != nil {
+			m.config.OnError(err)
+			continue
+		}
+		if _, ok := idx.candidates(pattern); !ok {
+			continue
+		}
+
+		entries, _, _, err := readSegmentFile(m.segmentFilename(segment.id))
+		if err != nil {
+			m.config.OnError(err)
+			continue
+		}
+		edited, count := apply(entries)
+		if count == 0 {
+			continue
+		}
+		offsets, err := writeSegmentFile(m.segmentFilename(segment.id), edited)
+		if err == nil {
+			_, err = m.writeIndex(segment.id, edited, offsets)
+		}
+		if err != nil {
+			m.config.OnError(err)
+			continue
+		}
+		m.total -= segment.count - len(edited)
+		segment.count = len(edited)
+		total += count
+	}
+
+	if m.active != nil {
+		edited, count := apply(m.active.entries)
+		if count > 0 {
+			_, err := writeSegmentFile(m.segmentFilename(m.active.id), edited)
+			if err != nil {
+				m.config.OnError(err)
+				return total
+			}
+			m.active.file.Close()
+			m.total -= len(m.active.entries)
+			id := m.active.id
+			m.active = nil
+			err = m.openActive(id)
+			if err != nil {
+				m.config.OnError(err)
+			}
+			total += count
+		}
+	}
+	if m.prev != nil && m.prev.Command == command {
+		m.prev = nil
+	}
+
+	return total
+}
+
+// writeSegmentFile atomically replaces a segment file with the entries, returning the offset of each record followed by
+// the end of the last
+func writeSegmentFile(filename string, entries []*HistoryEntry) ([]int64, error) {
+	var data strings.Builder
+	data.WriteString(encodeHistoryHeader(HistoryFormatJSONLines) + "\n")
+	offsets := []int64{}
+	for _, entry := range entries {
+		offsets = append(offsets, int64(data.Len()))
+		data.WriteString(encodeHistoryRecord(HistoryFormatJSONLines, entry) + "\n")
+	}
+	offsets = append(offsets, int64(data.Len()))
+
+	return offsets, writeFileAtomic(filename, []byte(data.String()))
+}
+
+// Delete removes every occurrence of the command from the underlying history manager, in every namespace.  Nothing is
+// removed if the underlying manager isn't editable.
+func (nh *NamespacedHistory) Delete(command string) int {
+	if ehm, ok := nh.hm.(EditableHistoryManager); ok {
+		return ehm.Delete(command)
+	}
+
+	return 0
+}
+
+// Replace replaces every occurrence of the command in the underlying history manager, in every namespace
+func (nh *NamespacedHistory) Replace(command string, replacement string) int {
+	if ehm, ok := nh.hm.(EditableHistoryManager); ok {
+		return ehm.Replace(command, replacement)
+	}
+
+	return 0
+}
+
+// deleteSearchResult deletes the command shown by the incremental search from the history, and moves on to the next match
+func (r *Reader) deleteSearchResult() {
+	hm, ok := r.config.HistoryManager.(EditableHistoryManager)
+	if !ok || r.lastSuggestion == "" {
+		return
+	}
+
+	hm.Delete(r.lastSuggestion)
+	index := r.searchIndex
+	r.searchResults = r.config.HistoryManager.Search(r.searchQuery)
+	if index >= len(r.searchResults) {
+		index = len(r.searchResults) - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	r.searchIndex = index
+	r.searchFailing = len(r.searchQuery) > 0 && len(r.searchResults) == 0
+	r.requireFullRender = true
+}
+
+// deletePickerEntry deletes the command selected in the history picker from the history
+func (r *Reader) deletePickerEntry() {
+	hm, ok := r.config.HistoryManager.(EditableHistoryManager)
+	entry := r.selectedPickerEntry()
+	if !ok || entry == nil {
+		return
+	}
+
+	hm.Delete(entry.Command)
+	entries := []*HistoryEntry{}
+	for _, e := range r.pickerEntries {
+		if e.Command != entry.Command {
+			entries = append(entries, e)
+		}
+	}
+	r.pickerEntries = entries
+	r.pickerItems = append(r.pickerItems[:r.pickerIndex], r.pickerItems[r.pickerIndex+1:]...)
+	r.movePicker(0)
+}
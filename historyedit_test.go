@@ -0,0 +1,140 @@
+package ns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicHistoryEdit(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	hm.Push("login hunter2")
+	hm.Push("ls")
+	hm.Push("login hunter2")
+	hm.Push("gti status")
+
+	assert.Equal(t, 2, hm.Delete("login hunter2"))
+	assert.Nil(t, hm.Search("hunter2"))
+	assert.Equal(t, 0, hm.Usage("login hunter2").Count)
+
+	assert.Equal(t, 1, hm.Replace("gti status", "git status"))
+	assert.Equal(t, []string{"ls", "git status"}, commandsOf(hm.Entries()))
+	assert.Equal(t, []string{"git status"}, hm.Search("git"))
+	assert.Equal(t, 1, hm.Usage("git status").Count)
+	assert.Equal(t, "git status", hm.GetIterator().Backward())
+}
+
+func TestPersistedHistoryEdit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{MaxKeep: 10, Filename: filename, RankMode: RankFrecency})
+	pm.Push("login hunter2")
+	pm.Push("ls")
+	pm.Push("gti status")
+	assert.NoError(t, pm.flushChanges())
+
+	assert.Equal(t, 1, pm.Delete("login hunter2"))
+	assert.Equal(t, 1, pm.Replace("gti status", "git status"))
+	pm.Exit()
+
+	for _, name := range []string{filename, filename + ".usage"} {
+		data, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.False(t, strings.Contains(string(data), "hunter2"), name)
+	}
+
+	pm = NewPersistedHistoryManager(10, filename)
+	defer pm.Exit()
+	assert.Equal(t, []string{"ls", "git status"}, commandsOf(pm.Entries()))
+}
+
+func TestSegmentHistoryEdit(t *testing.T) {
+	config := SegmentHistoryConfig{MaxKeep: 100, Dir: t.TempDir(), SegmentSize: 2}
+	m, err := NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	m.Push("login hunter2")
+	for i := 0; i < 3; i++ {
+		m.Push(fmt.Sprintf("cmd %d", i))
+	}
+	m.Push("login hunter2")
+
+	assert.Equal(t, 2, m.Delete("login hunter2"))
+	assert.Nil(t, m.Search("hunter2"))
+	assert.Equal(t, 1, m.Replace("cmd 0", "cmd zero"))
+	m.Exit()
+
+	m, err = NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	defer m.Exit()
+	assert.Equal(t, []string{"cmd zero", "cmd 1", "cmd 2"}, commandsOf(m.Entries()))
+	assert.Nil(t, m.Search("hunter2"))
+}
+
+func TestSearchDelete(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	for _, cmd := range []string{"git status", "git push --token abc", "git log"} {
+		hm.Push(cmd)
+		time.Sleep(time.Millisecond)
+	}
+
+	r := NewReader(ReaderConfig{HistoryManager: hm})
+	r.searchMode = true
+	r.updateBuffer("git")
+	r.updateSearch()
+	r.stepSearch(1)
+	r.updateSearch()
+	assert.Equal(t, "git push --token abc", r.lastSuggestion)
+
+	r.deleteSearchResult()
+	r.updateSearch()
+	assert.Equal(t, "git status", r.lastSuggestion)
+	assert.Nil(t, hm.Search("token"))
+
+	r.resetSearch()
+	r.readBuffer = []rune{}
+	r.startPicker()
+	r.updatePicker()
+	r.deletePickerEntry()
+	assert.Equal(t, 1, len(r.pickerItems))
+	assert.Equal(t, []string{"git status"}, commandsOf(hm.Entries()))
+}
+
+func TestBasicHistoryReplaceKeepsRecency(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	hm.Push("git add")
+	hm.Push("git status")
+	hm.Push("git log")
+	assert.Equal(t, 1, hm.Replace("git add", "git add -p"))
+	assert.Equal(t, []string{"git log", "git status", "git add -p"}, hm.Search("git"))
+}
+
+func TestPersistedHistoryEditRetried(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	// without the flush thread, so that only the flushes below write the file
+	pm, err := newPersistedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename})
+	assert.NoError(t, err)
+	pm.Push("login hunter2")
+	pm.Push("ls")
+	assert.NoError(t, pm.flushChanges())
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	// the history file can't be read, so the edit is kept for the next flush
+	assert.NoError(t, os.Rename(filename, filename+".moved"))
+	assert.NoError(t, os.Mkdir(filename, 0755))
+	assert.Equal(t, 1, pm.Delete("login hunter2"))
+	assert.Error(t, pm.flushChanges())
+	assert.Equal(t, 1, len(pm.pendingEdits))
+	assert.Equal(t, []string{"ls"}, commandsOf(pm.Entries()))
+
+	assert.NoError(t, os.Remove(filename))
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+	assert.NoError(t, pm.flushChanges())
+	data, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "hunter2"))
+}
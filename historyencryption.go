@@ -0,0 +1,281 @@
+package ns
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	historyCipherName    = "aes-gcm"
+	historyKDFIterations = 600000
+	historyKDFSaltSize   = 16
+)
+
+// nonceReader supplies the random nonce of each sealed record
+var nonceReader = rand.Reader
+
+var (
+	// ErrWrongHistoryKey is returned when the records of an encrypted history file were written with a key which isn't
+	// configured
+	ErrWrongHistoryKey = errors.New("history file is encrypted with a different key")
+	// ErrHistoryEncrypted is returned when an encrypted history file is opened without a key
+	ErrHistoryEncrypted = errors.New("history file is encrypted")
+)
+
+// HistoryEncryption configures the encryption of a persisted history file.  Each record is encrypted with AES-GCM, using
+// either the key supplied by the application or one derived from a passphrase with PBKDF2.
+type HistoryEncryption struct {
+	// Key is a 16, 24 or 32 byte AES key
+	Key []byte
+	// Passphrase derives the key when no Key is supplied.  The salt is stored in the header of the history file.
+	Passphrase string
+	// PreviousKeys and PreviousPassphrases decrypt records written before the key was changed.  Such records are
+	// re-encrypted with the current key when the history is loaded.
+	PreviousKeys        [][]byte
+	PreviousPassphrases []string
+}
+
+// historyKey is an AES-GCM key, identified in each record by the truncated hash of the key
+type historyKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// encryptedRecord is a single encrypted line of a history file, the data being the nonce followed by the sealed JSON entry
+type encryptedRecord struct {
+	KeyID string `json:"kid"`
+	Data  string `json:"enc"`
+}
+
+// historyCodec encodes and decodes the header and records of a history file.  When keys are present, the first is used to
+// encrypt and all of them to decrypt.
+type historyCodec struct {
+	format HistoryFormat
+	keys   []*historyKey
+	salt   []byte
+}
+
+// DeriveHistoryKey derives a 32 byte AES key from a passphrase and salt using PBKDF2 with SHA-256
+func DeriveHistoryKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, historyKDFIterations, 32)
+}
+
+func newHistoryKey(key []byte) (*historyKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+	return &historyKey{
+		id:   hex.EncodeToString(sum[:8]),
+		aead: aead,
+	}, nil
+}
+
+// newHistoryCodec creates the codec for the format, encrypting records when encryption is supplied.  The salt is used to
+// derive keys from passphrases, a new one is generated if none is supplied.
+func newHistoryCodec(format HistoryFormat, encryption *HistoryEncryption, salt []byte) (*historyCodec, error) {
+	codec := &historyCodec{format: format}
+	if encryption == nil {
+		return codec, nil
+	}
+
+	// records are always JSON once encrypted
+	codec.format = HistoryFormatJSONLines
+	if encryption.Passphrase != "" || len(encryption.PreviousPassphrases) > 0 {
+		if len(salt) == 0 {
+			salt = make([]byte, historyKDFSaltSize)
+			if _, err := rand.Read(salt); err != nil {
+				return nil, err
+			}
+		}
+		codec.salt = salt
+	}
+
+	rawKeys := [][]byte{}
+	if len(encryption.Key) > 0 {
+		rawKeys = append(rawKeys, encryption.Key)
+	} else if encryption.Passphrase != "" {
+		key, err := DeriveHistoryKey(encryption.Passphrase, codec.salt)
+		if err != nil {
+			return nil, err
+		}
+		rawKeys = append(rawKeys, key)
+	} else {
+		return nil, errors.New("history encryption requires a key or passphrase")
+	}
+	rawKeys = append(rawKeys, encryption.PreviousKeys...)
+	for _, passphrase := range encryption.PreviousPassphrases {
+		key, err := DeriveHistoryKey(passphrase, codec.salt)
+		if err != nil {
+			return nil, err
+		}
+		rawKeys = append(rawKeys, key)
+	}
+
+	for _, rawKey := range rawKeys {
+		key, err := newHistoryKey(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history key: %w", err)
+		}
+		codec.keys = append(codec.keys, key)
+	}
+
+	return codec, nil
+}
+
+func (c *historyCodec) encrypted() bool {
+	return len(c.keys) > 0
+}
+
+// header returns the header line of the file, or an empty string if the format has none
+func (c *historyCodec) header() string {
+	if !c.encrypted() {
+		return encodeHistoryHeader(c.format)
+	}
+
+	headerBytes, _ := json.Marshal(&historyHeader{
+		Format:  historyFormatName,
+		Version: historyFormatVersion,
+		Cipher:  historyCipherName,
+		Salt:    c.salt,
+	})
+	return string(headerBytes)
+}
+
+// encode encodes an entry as a single line
+func (c *historyCodec) encode(entry *HistoryEntry) (string, error) {
+	if !c.encrypted() {
+		return encodeHistoryRecord(c.format, entry), nil
+	}
+
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return c.seal(plaintext)
+}
+
+// seal encrypts the data as a single line, or returns it unchanged when not encrypting
+func (c *historyCodec) seal(plaintext []byte) (string, error) {
+	if !c.encrypted() {
+		return string(plaintext), nil
+	}
+
+	key := c.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	// a nonce must never be reused with the key, so nothing is written without a random one
+	if _, err := io.ReadFull(nonceReader, nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, plaintext, []byte(key.id))
+
+	recordBytes, err := json.Marshal(&encryptedRecord{
+		KeyID: key.id,
+		Data:  base64.StdEncoding.EncodeToString(sealed),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(recordBytes), nil
+}
+
+// decode decodes a single line, decrypting it if it is encrypted
+func (c *historyCodec) decode(line string) (*HistoryEntry, error) {
+	if _, ok := decodeEncryptedRecord(line); !ok {
+		return decodeHistoryRecord(line)
+	}
+
+	plaintext, err := c.open(line)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &HistoryEntry{}
+	if err := json.Unmarshal(plaintext, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// open decrypts a line produced by seal, lines which aren't encrypted are returned unchanged
+func (c *historyCodec) open(line string) ([]byte, error) {
+	line = strings.TrimRight(line, "\r\n")
+	record, ok := decodeEncryptedRecord(line)
+	if !ok {
+		return []byte(line), nil
+	}
+	if !c.encrypted() {
+		return nil, ErrHistoryEncrypted
+	}
+
+	var key *historyKey
+	for _, k := range c.keys {
+		if k.id == record.KeyID {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, ErrWrongHistoryKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(record.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < key.aead.NonceSize() {
+		return nil, errors.New("encrypted record is truncated")
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	return key.aead.Open(nil, nonce, ciphertext, []byte(key.id))
+}
+
+// isCurrent returns true if the line is encoded as the codec would encode it, including the key used to encrypt it
+func (c *historyCodec) isCurrent(line string) bool {
+	record, ok := decodeEncryptedRecord(line)
+	if c.encrypted() {
+		return ok && record.KeyID == c.keys[0].id
+	}
+
+	return !ok && strings.HasPrefix(line, "{") == (c.format == HistoryFormatJSONLines)
+}
+
+// matchesHeader returns true if the header is the one the codec would write
+func (c *historyCodec) matchesHeader(header *historyHeader, hasHeader bool) bool {
+	if c.encrypted() {
+		return hasHeader && header.Cipher == historyCipherName && string(header.Salt) == string(c.salt)
+	}
+
+	return hasHeader == (c.format == HistoryFormatJSONLines) && header.Cipher == ""
+}
+
+func decodeEncryptedRecord(line string) (*encryptedRecord, bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasPrefix(line, `{"kid":`) {
+		return nil, false
+	}
+
+	record := &encryptedRecord{}
+	if err := json.Unmarshal([]byte(line), record); err != nil || record.KeyID == "" {
+		return nil, false
+	}
+
+	return record, true
+}
@@ -0,0 +1,140 @@
+package ns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	pm, err := NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: key})
+	assert.NoError(t, err)
+	pm.Push("login --customer acme")
+	pm.Exit()
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "acme"))
+
+	pm, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: key})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login --customer acme"}, pm.Search("acme"))
+	pm.Exit()
+
+	_, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: bytes.Repeat([]byte{2}, 32)})
+	assert.True(t, errors.Is(err, ErrWrongHistoryKey))
+
+	var loadErr error
+	plain := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		OnError:  func(err error) { loadErr = err },
+	})
+	plain.Push("ls")
+	plain.Exit()
+	assert.True(t, errors.Is(loadErr, ErrHistoryEncrypted))
+
+	after, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, data, after)
+}
+
+func TestEncryptedHistoryNonceFailure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	key := bytes.Repeat([]byte{1}, 32)
+	pm, err := newPersistedHistoryManager(PersistedHistoryConfig{
+		MaxKeep:    10,
+		Filename:   filename,
+		Encryption: &HistoryEncryption{Key: key},
+	})
+	assert.NoError(t, err)
+
+	// nothing is written without a random nonce, and the record is written once one is available
+	nonceReader = iotest.ErrReader(errors.New("no entropy"))
+	pm.Push("login --customer acme")
+	assert.Error(t, pm.flushChanges())
+	nonceReader = rand.Reader
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, pm.flushChanges())
+
+	pm, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: key})
+	assert.NoError(t, err)
+	defer pm.Exit()
+	assert.Equal(t, []string{"login --customer acme"}, pm.Search("acme"))
+}
+
+func TestEncryptedHistoryKeyRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	oldKey := bytes.Repeat([]byte{1}, 16)
+	newKey := bytes.Repeat([]byte{2}, 16)
+
+	pm, err := NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: oldKey})
+	assert.NoError(t, err)
+	pm.Push("first")
+	pm.Exit()
+
+	// the previous key decrypts the file, which is then re-encrypted with the new key
+	pm, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{
+		Key:          newKey,
+		PreviousKeys: [][]byte{oldKey},
+	})
+	assert.NoError(t, err)
+	pm.Exit()
+
+	pm, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: newKey})
+	assert.NoError(t, err)
+	pm.Push("second")
+	assert.NoError(t, pm.RotatePassphrase("correct horse"))
+	pm.Exit()
+
+	_, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Key: newKey})
+	assert.True(t, errors.Is(err, ErrWrongHistoryKey))
+
+	pm, err = NewEncryptedHistoryManager(PersistedHistoryConfig{MaxKeep: 10, Filename: filename}, HistoryEncryption{Passphrase: "correct horse"})
+	assert.NoError(t, err)
+	defer pm.Exit()
+	assert.Equal(t, []string{"first", "second"}, commandsOf(pm.Entries()))
+}
+
+func TestEncryptedHistoryRotateWhileWriting(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:           1000,
+		Filename:          filename,
+		WritePolicy:       WriteBatched,
+		BatchSize:         1,
+		ShareHistory:      true,
+		SharePollInterval: time.Millisecond,
+	}
+	pm, err := NewEncryptedHistoryManager(config, HistoryEncryption{Key: bytes.Repeat([]byte{1}, 32)})
+	assert.NoError(t, err)
+
+	// the flush thread writes and polls with the codec while it is rotated from this goroutine
+	key := bytes.Repeat([]byte{2}, 32)
+	for i := 0; i < 50; i++ {
+		pm.Push(fmt.Sprintf("cmd %d", i))
+		if i%10 == 0 {
+			key = bytes.Repeat([]byte{byte(i + 2)}, 32)
+			assert.NoError(t, pm.RotateKey(key))
+		}
+	}
+	pm.Exit()
+
+	pm, err = NewEncryptedHistoryManager(config, HistoryEncryption{Key: key})
+	assert.NoError(t, err)
+	defer pm.Exit()
+	assert.Equal(t, 50, len(pm.Entries()))
+}
@@ -0,0 +1,386 @@
+package ns
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+var (
+	// ErrHistoryEventNotFound is returned when a history reference matches no command
+	ErrHistoryEventNotFound = errors.New("event not found")
+	// ErrBadWordSpecifier is returned when a word designator refers to words the command doesn't have
+	ErrBadWordSpecifier = errors.New("bad word specifier")
+	// ErrSubstitutionFailed is returned when the text to substitute isn't found in the command
+	ErrSubstitutionFailed = errors.New("substitution failed")
+)
+
+// HistoryExpansionConfig enables bash-style history expansion, eg. `sudo !!` or `^old^new`
+type HistoryExpansionConfig struct {
+	// ExpandOnSpace expands history references before the cursor as space is typed, so that the result is visible before
+	// the command is submitted
+	ExpandOnSpace bool
+}
+
+// historyWordEnd holds the shell metacharacters and quotes which end the word of a history reference, as in bash
+const historyWordEnd = ";|&()<>\"'"
+
+// hasHistoryReference returns true if the line may contain a history reference, avoiding the cost of fetching the
+// history for most lines
+func hasHistoryReference(line string) bool {
+	return strings.Contains(line, "!") || strings.HasPrefix(line, "^")
+}
+
+// ExpandHistory performs bash-style history expansion of the line, with the history ordered from oldest to most recent.
+// The supported event designators are !!, !n, !-n, !prefix and !?substring?, as well as ^old^new quick substitution.
+// Events may be followed by word designators (:n, :x-y, ^, $, *) and the h, t, r, e, s/old/new/, gs/old/new/ and p
+// modifiers.  printOnly is true if the p modifier was used, in which case the command should be shown rather than run.
+func ExpandHistory(line string, history []string) (expanded string, printOnly bool, err error) {
+	if strings.HasPrefix(line, "^") {
+		return quickSubstitution(line, history)
+	}
+
+	var out strings.Builder
+	inSingleQuote := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			out.WriteByte(c)
+			out.WriteByte(line[i+1])
+			i++
+		case c == '\'':
+			inSingleQuote = !inSingleQuote
+			out.WriteByte(c)
+		case c == '!' && !inSingleQuote && i+1 < len(line) && !strings.ContainsRune(" \t\n="+historyWordEnd, rune(line[i+1])):
+			// a ! ending a word, such as in "hi!", isn't a reference
+			expansion, length, p, err := expandHistoryReference(line[i:], history)
+			if err != nil {
+				return line, false, err
+			}
+			out.WriteString(expansion)
+			printOnly = printOnly || p
+			i += length - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.String(), printOnly, nil
+}
+
+// quickSubstitution expands ^old^new^ into the previous command with the first occurrence of old replaced by new
+func quickSubstitution(line string, history []string) (string, bool, error) {
+	parts := strings.SplitN(line[1:], "^", 3)
+	if len(history) == 0 {
+		return line, false, fmt.Errorf("%s: %w", line, ErrHistoryEventNotFound)
+	}
+
+	prev := history[len(history)-1]
+	if len(parts) < 2 || parts[0] == "" || !strings.Contains(prev, parts[0]) {
+		return line, false, fmt.Errorf("%s: %w", line, ErrSubstitutionFailed)
+	}
+	expanded := strings.Replace(prev, parts[0], parts[1], 1)
+	if len(parts) == 3 {
+		expanded += parts[2]
+	}
+
+	return expanded, false, nil
+}
+
+// expandHistoryReference expands the reference at the start of s, returning the expansion and the length of the reference
+func expandHistoryReference(s string, history []string) (string, int, bool, error) {
+	i := 1
+	var event string
+	found := false
+	switch {
+	case s[1] == '!':
+		i = 2
+		if len(history) > 0 {
+			event, found = history[len(history)-1], true
+		}
+	case strings.ContainsRune("^$*:", rune(s[1])):
+		// a word designator alone refers to the previous command
+		if len(history) > 0 {
+			event, found = history[len(history)-1], true
+		}
+	case s[1] == '?':
+		end := strings.IndexAny(s[2:], "?\n")
+		substr := s[2:]
+		i = len(s)
+		if end >= 0 {
+			substr = s[2 : 2+end]
+			i = 3 + end
+		}
+		for j := len(history) - 1; j >= 0 && substr != ""; j-- {
+			if strings.Contains(history[j], substr) {
+				event, found = history[j], true
+				break
+			}
+		}
+	case isDigit(rune(s[1])) || (s[1] == '-' && len(s) > 2 && isDigit(rune(s[2]))):
+		i = 2
+		for i < len(s) && isDigit(rune(s[i])) {
+			i++
+		}
+		n, _ := strconv.Atoi(s[1:i])
+		if n < 0 {
+			n = len(history) + n + 1
+		}
+		if n >= 1 && n <= len(history) {
+			event, found = history[n-1], true
+		}
+	default:
+		for i < len(s) && !strings.ContainsRune(" \t\n:"+historyWordEnd, rune(s[i])) {
+			i++
+		}
+		prefix := s[1:i]
+		for j := len(history) - 1; j >= 0; j-- {
+			if strings.HasPrefix(history[j], prefix) {
+				event, found = history[j], true
+				break
+			}
+		}
+	}
+	if !found {
+		return "", 0, false, fmt.Errorf("%s: %w", s[:i], ErrHistoryEventNotFound)
+	}
+
+	expansion := event
+	if i < len(s) && (strings.ContainsRune("^$*", rune(s[i])) || (s[i] == ':' && i+1 < len(s) && strings.ContainsRune("0123456789^$*-", rune(s[i+1])))) {
+		if s[i] == ':' {
+			i++
+		}
+		var err error
+		var length int
+		expansion, length, err = selectHistoryWords(s[i:], splitHistoryWords(event))
+		if err != nil {
+			return "", 0, false, fmt.Errorf("%s: %w", s[:i+length], err)
+		}
+		i += length
+	}
+
+	printOnly := false
+	for i+1 < len(s) && s[i] == ':' {
+		modifier := s[i+1]
+		switch modifier {
+		case 'p':
+			printOnly = true
+			i += 2
+		case 'h':
+			expansion = path.Dir(expansion)
+			i += 2
+		case 't':
+			expansion = path.Base(expansion)
+			i += 2
+		case 'r':
+			expansion = strings.TrimSuffix(expansion, path.Ext(expansion))
+			i += 2
+		case 'e':
+			expansion = path.Ext(expansion)
+			i += 2
+		case 's', 'g':
+			global := modifier == 'g'
+			start := i + 2
+			if global {
+				if start >= len(s) || s[start] != 's' {
+					return expansion, i, printOnly, nil
+				}
+				start++
+			}
+			substituted, length, err := substituteHistory(s[start:], expansion, global)
+			if err != nil {
+				return "", 0, false, fmt.Errorf("%s: %w", s[:start+length], err)
+			}
+			expansion = substituted
+			i = start + length
+		default:
+			return expansion, i, printOnly, nil
+		}
+	}
+
+	return expansion, i, printOnly, nil
+}
+
+// selectHistoryWords applies the word designator at the start of s to the words of a command, returning the selected
+// words and the length of the designator
+func selectHistoryWords(s string, words []string) (string, int, error) {
+	last := len(words) - 1
+	i := 0
+	readNumber := func() (int, bool) {
+		start := i
+		for i < len(s) && isDigit(rune(s[i])) {
+			i++
+		}
+		n, err := strconv.Atoi(s[start:i])
+		return n, err == nil
+	}
+
+	var from, to int
+	switch {
+	case s[0] == '*':
+		i = 1
+		if last < 1 {
+			return "", i, nil
+		}
+		from, to = 1, last
+	case s[0] == '^':
+		i = 1
+		from, to = 1, 1
+	case s[0] == '$':
+		i = 1
+		from, to = last, last
+	case s[0] == '-':
+		i = 1
+		from = 0
+		n, ok := readNumber()
+		if !ok {
+			return "", i, ErrBadWordSpecifier
+		}
+		to = n
+	default:
+		n, _ := readNumber()
+		from, to = n, n
+		if i < len(s) && s[i] == '*' {
+			i++
+			to = last
+		} else if i < len(s) && s[i] == '-' {
+			i++
+			if i < len(s) && s[i] == '$' {
+				i++
+				to = last
+			} else if n, ok := readNumber(); ok {
+				to = n
+			} else {
+				// x- abbreviates x-$ without the last word
+				to = last - 1
+			}
+		}
+	}
+
+	if from < 0 || to > last || from > to {
+		return "", i, ErrBadWordSpecifier
+	}
+
+	return strings.Join(words[from:to+1], " "), i, nil
+}
+
+// substituteHistory applies the substitution at the start of s, eg. /old/new/, in which & stands for the old text.  The
+// delimiter is the first character, and the final delimiter may be omitted at the end of the line.
+func substituteHistory(s string, text string, global bool) (string, int, error) {
+	if len(s) < 2 {
+		return "", len(s), ErrSubstitutionFailed
+	}
+
+	delim := s[:1]
+	parts := strings.SplitN(s[1:], delim, 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", len(s), ErrSubstitutionFailed
+	}
+	length := len(s)
+	if len(parts) == 3 {
+		length = 1 + len(parts[0]) + 1 + len(parts[1]) + 1
+	}
+
+	old := parts[0]
+	replacement := strings.ReplaceAll(parts[1], "&", old)
+	if !strings.Contains(text, old) {
+		return "", length, ErrSubstitutionFailed
+	}
+	if global {
+		return strings.ReplaceAll(text, old, replacement), length, nil
+	}
+
+	return strings.Replace(text, old, replacement, 1), length, nil
+}
+
+// splitHistoryWords splits a command into words at whitespace, keeping quoted strings together
+func splitHistoryWords(command string) []string {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '\\' && i+1 < len(command):
+			word.WriteByte(c)
+			c = command[i+1]
+			i++
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteByte(c)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// historyCommands returns the commands in the history, from oldest to most recent
+func (r *Reader) historyCommands() []string {
+	entries := historyEntries(r.config.HistoryManager)
+	commands := make([]string, len(entries))
+	for i, entry := range entries {
+		commands[i] = entry.Command
+	}
+
+	return commands
+}
+
+// expandHistory expands the history references in the buffer before the cursor.  When submitting, the whole buffer is
+// expanded and true is returned if the command shouldn't be run yet, either because the expansion failed (which is shown
+// in the panel) or because it was to be printed only.
+func (r *Reader) expandHistory(prompt string, submit bool) bool {
+	if r.config.HistoryExpansion == nil {
+		return false
+	}
+
+	end := r.editOffset
+	if submit {
+		end = len(r.readBuffer)
+	}
+	line := string(r.readBuffer[:end])
+	if !hasHistoryReference(line) {
+		return false
+	}
+
+	expanded, printOnly, err := ExpandHistory(line, r.historyCommands())
+	if err != nil {
+		if submit {
+			r.panel = termutils.Box([]string{err.Error()}, r.windowSize.Columns)
+			r.requireFullRender = true
+		}
+		return submit
+	}
+	if expanded == line {
+		return false
+	}
+
+	r.readBuffer = append([]rune(expanded), r.readBuffer[end:]...)
+	r.editOffset = len([]rune(expanded))
+	r.requireFullRender = true
+	if submit && !printOnly {
+		r.renderFinal(prompt)
+	}
+
+	return submit && printOnly
+}
@@ -0,0 +1,64 @@
+package ns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandHistory(t *testing.T) {
+	history := []string{
+		"ls -la /tmp",
+		"git commit -m 'fix build'",
+		"cp notes.txt /srv/docs/report.txt",
+		"apt install vim",
+	}
+
+	cases := []struct {
+		line      string
+		expected  string
+		printOnly bool
+	}{
+		{"sudo !!", "sudo apt install vim", false},
+		{"echo !$", "echo vim", false},
+		{"echo !^", "echo install", false},
+		{"echo !*", "echo install vim", false},
+		{"!1", "ls -la /tmp", false},
+		{"!-2", "cp notes.txt /srv/docs/report.txt", false},
+		{"!git", "git commit -m 'fix build'", false},
+		{"!?notes? again", "cp notes.txt /srv/docs/report.txt again", false},
+		{"!git:3", "'fix build'", false},
+		{"!cp:1-2", "notes.txt /srv/docs/report.txt", false},
+		{"!cp:0-", "cp notes.txt", false},
+		{"cat !cp:2:h", "cat /srv/docs", false},
+		{"cat !cp:2:t:r", "cat report", false},
+		{"!!:s/vim/emacs/", "apt install emacs", false},
+		{"!ls:gs/l/L/", "Ls -La /tmp", false},
+		{"!!:p", "apt install vim", true},
+		{"^vim^nano", "apt install nano", false},
+		{"echo '!!' \\!! a != b", "echo '!!' \\!! a != b", false},
+		{`echo "hi!"`, `echo "hi!"`, false},
+		{"echo hi! (yes!) ok!|cat", "echo hi! (yes!) ok!|cat", false},
+		{"!git;ls", "git commit -m 'fix build';ls", false},
+		{"!apt|tee log", "apt install vim|tee log", false},
+		{"(!ls)", "(ls -la /tmp)", false},
+		{`echo "!cp"`, `echo "cp notes.txt /srv/docs/report.txt"`, false},
+	}
+
+	for _, c := range cases {
+		expanded, printOnly, err := ExpandHistory(c.line, history)
+		assert.NoError(t, err, c.line)
+		assert.Equal(t, c.expected, expanded, c.line)
+		assert.Equal(t, c.printOnly, printOnly, c.line)
+	}
+
+	_, _, err := ExpandHistory("!nothing", history)
+	assert.True(t, errors.Is(err, ErrHistoryEventNotFound))
+	_, _, err = ExpandHistory("!!:9", history)
+	assert.True(t, errors.Is(err, ErrBadWordSpecifier))
+	_, _, err = ExpandHistory("^emacs^vim", history)
+	assert.True(t, errors.Is(err, ErrSubstitutionFailed))
+	_, _, err = ExpandHistory("sudo !!", nil)
+	assert.True(t, errors.Is(err, ErrHistoryEventNotFound))
+}
@@ -0,0 +1,106 @@
+package ns
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactions match common forms of secrets in commands, such as --password=..., TOKEN=... assignments, bearer
+// tokens, credentials in URLs, and well known access key formats
+var DefaultRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)--?(?:password|passwd|pwd|token|secret|api[-_]?key|access[-_]?key|auth[-_]?token)(?:=|\s+)(\S+)`),
+	regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:PASSWORD|PASSWD|TOKEN|SECRET|API_?KEY)[A-Z0-9_]*=(\S+)`),
+	regexp.MustCompile(`(?i)authorization:\s*(?:bearer|basic|token)\s+([^\s'"]+)`),
+	regexp.MustCompile(`://[^/\s:@]+:([^@\s/]+)@`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]+`),
+}
+
+// HistoryFilter determines which submitted commands are recorded in the history, and redacts secrets from those which are
+type HistoryFilter struct {
+	// IgnoreSpace skips commands which begin with a space (like HISTCONTROL=ignorespace)
+	IgnoreSpace bool
+	// IgnoreGlobs skips commands which match any of the glob patterns in their entirety, where * matches any text and ?
+	// matches any single character
+	IgnoreGlobs []string
+	// IgnorePatterns skips commands which match any of the regular expressions
+	IgnorePatterns []*regexp.Regexp
+	// Redactions replace the secrets they match with the placeholder.  When a pattern contains capture groups, only the
+	// text of the groups is replaced.
+	Redactions []*regexp.Regexp
+	// RedactionPlaceholder replaces redacted text (default "<redacted>")
+	RedactionPlaceholder string
+}
+
+// Apply returns the command as it should be recorded, or false if it should not be recorded at all
+func (f *HistoryFilter) Apply(command string) (string, bool) {
+	if f.IgnoreSpace && strings.HasPrefix(command, " ") {
+		return "", false
+	}
+
+	command = strings.Trim(command, " \t\r\n")
+	for _, glob := range f.IgnoreGlobs {
+		if globToRegexp(glob).MatchString(command) {
+			return "", false
+		}
+	}
+	for _, pattern := range f.IgnorePatterns {
+		if pattern.MatchString(command) {
+			return "", false
+		}
+	}
+
+	placeholder := f.RedactionPlaceholder
+	if placeholder == "" {
+		placeholder = "<redacted>"
+	}
+	for _, pattern := range f.Redactions {
+		command = redact(pattern, command, placeholder)
+	}
+
+	return command, true
+}
+
+// redact replaces the capture groups of each match (or each match, if there are no groups) with the placeholder
+func redact(pattern *regexp.Regexp, text string, placeholder string) string {
+	matches := pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, match := range matches {
+		spans := [][2]int{}
+		if len(match) == 2 {
+			spans = append(spans, [2]int{match[0], match[1]})
+		}
+		for g := 2; g+1 < len(match); g += 2 {
+			if match[g] >= 0 {
+				spans = append(spans, [2]int{match[g], match[g+1]})
+			}
+		}
+
+		for _, span := range spans {
+			if span[0] < last {
+				continue
+			}
+			sb.WriteString(text[last:span[0]])
+			sb.WriteString(placeholder)
+			last = span[1]
+		}
+	}
+	sb.WriteString(text[last:])
+
+	return sb.String()
+}
+
+// globToRegexp converts a glob pattern to a regular expression matching the entire text
+func globToRegexp(glob string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\?`, `.`)
+
+	return regexp.MustCompile(`^(?s:` + quoted + `)$`)
+}
@@ -0,0 +1,159 @@
+package ns
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HistoryFormat is the file format of a persisted history
+type HistoryFormat int
+
+const (
+	// HistoryFormatJSONLines writes a header record followed by one JSON encoded entry per line
+	HistoryFormatJSONLines HistoryFormat = iota
+	// HistoryFormatBase64 writes one base64 encoded command per line, followed by its base64 encoded details.  This is the
+	// format written by earlier versions.
+	HistoryFormatBase64
+)
+
+const (
+	historyFormatName    = "nilshell-history"
+	historyFormatVersion = 2
+)
+
+var (
+	ErrUnsupportedHistoryVersion = errors.New("unsupported history file version")
+	// ErrHistoryReadOnly is returned when modifying a history file which couldn't be loaded
+	ErrHistoryReadOnly = errors.New("history file is read only")
+	// ErrHistoryRecordTruncated reports a partially written record at the end of a history file, which is removed
+	ErrHistoryRecordTruncated = errors.New("history record truncated")
+)
+
+// historyHeader is the first record of a versioned history file
+type historyHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Cipher names the cipher of an encrypted file, and Salt is the salt from which its key was derived from a passphrase
+	Cipher string `json:"cipher,omitempty"`
+	Salt   []byte `json:"salt,omitempty"`
+}
+
+// HistoryLineError reports a history file line which could not be decoded
+type HistoryLineError struct {
+	Filename string
+	Line     int
+	Err      error
+}
+
+func (e *HistoryLineError) Error() string {
+	return fmt.Sprintf("%s:%d: unable to decode history record: %v", e.Filename, e.Line, e.Err)
+}
+
+func (e *HistoryLineError) Unwrap() error {
+	return e.Err
+}
+
+// encodeHistoryHeader returns the header line for the format, or an empty string if the format has none
+func encodeHistoryHeader(format HistoryFormat) string {
+	if format != HistoryFormatJSONLines {
+		return ""
+	}
+
+	headerBytes, _ := json.Marshal(&historyHeader{Format: historyFormatName, Version: historyFormatVersion})
+	return string(headerBytes)
+}
+
+// decodeHistoryHeader decodes the header of a history file from its first line.  Files without a header are version 1
+// (base64 encoded).
+func decodeHistoryHeader(line string) (*historyHeader, bool, error) {
+	if !strings.HasPrefix(line, "{") {
+		return &historyHeader{Version: 1}, false, nil
+	}
+
+	header := &historyHeader{}
+	if err := json.Unmarshal([]byte(line), header); err != nil || header.Format != historyFormatName {
+		return &historyHeader{Version: 1}, false, nil
+	}
+
+	if header.Version > historyFormatVersion {
+		return header, true, fmt.Errorf("%w: %d", ErrUnsupportedHistoryVersion, header.Version)
+	}
+
+	return header, true, nil
+}
+
+// encodeHistoryRecord encodes an entry as a single line in the supplied format
+func encodeHistoryRecord(format HistoryFormat, entry *HistoryEntry) string {
+	if format == HistoryFormatBase64 {
+		return encodeBase64Record(entry)
+	}
+
+	recordBytes, err := json.Marshal(entry)
+	if err != nil {
+		return encodeBase64Record(entry)
+	}
+
+	return string(recordBytes)
+}
+
+// decodeHistoryRecord decodes a single line in any supported format
+func decodeHistoryRecord(line string) (*HistoryEntry, error) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasPrefix(line, "{") {
+		return decodeBase64Record(line)
+	}
+
+	entry := &HistoryEntry{}
+	if err := json.Unmarshal([]byte(line), entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// encodeBase64Record encodes an entry as the base64 encoded command, followed by a space and the base64 encoded JSON of
+// its remaining details, when there are any
+func encodeBase64Record(entry *HistoryEntry) string {
+	line := base64.StdEncoding.EncodeToString([]byte(entry.Command))
+
+	details := *entry
+	details.Command = ""
+	if details == (HistoryEntry{}) {
+		return line
+	}
+
+	detailBytes, err := json.Marshal(&details)
+	if err != nil {
+		return line
+	}
+
+	return line + " " + base64.StdEncoding.EncodeToString(detailBytes)
+}
+
+// decodeBase64Record decodes a line produced by encodeBase64Record.  Lines containing only the command are accepted.
+func decodeBase64Record(line string) (*HistoryEntry, error) {
+	encCommand, encDetails, hasDetails := strings.Cut(line, " ")
+
+	entry := &HistoryEntry{}
+	if hasDetails {
+		detailBytes, err := base64.StdEncoding.DecodeString(encDetails)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(detailBytes, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(encCommand)
+	if err != nil {
+		return nil, err
+	}
+	entry.Command = string(b)
+
+	return entry, nil
+}
@@ -1,5 +1,10 @@
 package ns
 
+import (
+	"fmt"
+	"time"
+)
+
 type HistoryIterator interface {
 	Backward() string
 	Forward() string
@@ -11,3 +16,85 @@ type HistoryManager interface {
 	Push(string)
 	Search(string) []string
 }
+
+// HistoryEntry is a command in the history, along with the details of its execution
+type HistoryEntry struct {
+	Command    string        `json:"command,omitempty"`
+	StartedAt  time.Time     `json:"started_at,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	ExitStatus int           `json:"exit_status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	WorkingDir string        `json:"working_dir,omitempty"`
+	SessionID  string        `json:"session_id,omitempty"`
+	// Namespace is the context in which the command was run, such as a working directory or connected target, see
+	// NamespacedHistory
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// EntryHistoryManager is a history manager which records the details of each command's execution, in addition to the
+// command itself.  When the configured history manager implements it, the reader records an entry for each command.
+type EntryHistoryManager interface {
+	HistoryManager
+	PushEntry(*HistoryEntry)
+	// Entries returns all entries, from oldest to most recent
+	Entries() []*HistoryEntry
+	// SearchEntries returns all entries containing the pattern, from most recent to oldest
+	SearchEntries(string) []*HistoryEntry
+}
+
+// EditableHistoryManager is a history manager whose commands can be deleted or replaced, such as to scrub a mistyped
+// password.  When the configured history manager implements it, shift+delete deletes the command shown in the history
+// search and picker.
+type EditableHistoryManager interface {
+	HistoryManager
+	// Delete removes every occurrence of the command, returning the number removed
+	Delete(command string) int
+	// Replace replaces every occurrence of the command, returning the number replaced
+	Replace(command string, replacement string) int
+}
+
+// ExitError can be returned from the process function to indicate that the command failed with the supplied exit status.
+// Unlike other errors, it does not terminate the read loop.
+type ExitError struct {
+	Status int
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Status)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// historyEntries returns the entries of any history manager, from oldest to most recent.  Managers which don't record
+// entries are walked with their iterator, which is expected to repeat the oldest command once exhausted.
+func historyEntries(hm HistoryManager) []*HistoryEntry {
+	if ehm, ok := hm.(EntryHistoryManager); ok {
+		return ehm.Entries()
+	}
+
+	commands := []string{}
+	iter := hm.GetIterator()
+	prev := ""
+	for {
+		command := iter.Backward()
+		if command == "" || command == prev {
+			break
+		}
+		commands = append(commands, command)
+		prev = command
+	}
+
+	entries := make([]*HistoryEntry, len(commands))
+	for i, command := range commands {
+		entries[len(commands)-1-i] = &HistoryEntry{Command: command}
+	}
+
+	return entries
+}
@@ -0,0 +1,94 @@
+package ns
+
+import "strings"
+
+// historyNavigator walks the history with up/down, visiting only commands which begin with the prefix typed before
+// navigation began.  Edits made to recalled commands are kept until the line is submitted.
+type historyNavigator struct {
+	iter      HistoryIterator
+	prefix    string
+	original  []rune
+	commands  []string
+	seen      map[string]struct{}
+	edits     map[int][]rune
+	index     int
+	prev      string
+	exhausted bool
+}
+
+func newHistoryNavigator(hm HistoryManager, buffer []rune, prefix string) *historyNavigator {
+	original := make([]rune, len(buffer))
+	copy(original, buffer)
+
+	return &historyNavigator{
+		iter:     hm.GetIterator(),
+		prefix:   prefix,
+		original: original,
+		seen:     map[string]struct{}{},
+		edits:    map[int][]rune{},
+		index:    -1,
+	}
+}
+
+// fetch retrieves the next older matching command from the iterator, returning false once there are no more
+func (n *historyNavigator) fetch() bool {
+	for !n.exhausted {
+		command := n.iter.Backward()
+		if command == "" || command == n.prev {
+			// iterators repeat the oldest command once exhausted
+			n.exhausted = true
+			break
+		}
+		n.prev = command
+
+		if !strings.HasPrefix(command, n.prefix) || command == n.prefix {
+			continue
+		}
+		if _, ok := n.seen[command]; ok {
+			continue
+		}
+		n.seen[command] = struct{}{}
+		n.commands = append(n.commands, command)
+		return true
+	}
+
+	return false
+}
+
+// move saves the current buffer against the current position, and moves to an older (positive direction) or newer
+// command, returning its buffer (including any prior edits) and true if the position changed
+func (n *historyNavigator) move(current []rune, direction int) ([]rune, bool) {
+	next := n.index + direction
+	if next < -1 {
+		return nil, false
+	}
+	if next >= len(n.commands) && !n.fetch() {
+		return nil, false
+	}
+
+	saved := make([]rune, len(current))
+	copy(saved, current)
+	if n.index < 0 {
+		n.original = saved
+	} else if string(saved) != n.commands[n.index] {
+		n.edits[n.index] = saved
+	} else {
+		delete(n.edits, n.index)
+	}
+
+	n.index = next
+	if n.index < 0 {
+		return n.original, true
+	}
+	if edited, ok := n.edits[n.index]; ok {
+		return edited, true
+	}
+
+	return []rune(n.commands[n.index]), true
+}
+
+// isOriginal returns true if the navigator is positioned at the line being edited before navigation began, and the line
+// has not been altered since
+func (n *historyNavigator) isOriginal(current []rune) bool {
+	return n.index < 0 && string(current) == string(n.original)
+}
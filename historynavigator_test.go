@@ -0,0 +1,51 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryNavigatorPrefix(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	for _, cmd := range []string{"git status", "ls", "git log", "make", "git log"} {
+		hm.Push(cmd)
+	}
+
+	n := newHistoryNavigator(hm, []rune("git"), "git")
+	buf, ok := n.move([]rune("git"), 1)
+	assert.True(t, ok)
+	assert.Equal(t, "git log", string(buf))
+
+	buf, ok = n.move(buf, 1)
+	assert.True(t, ok)
+	assert.Equal(t, "git status", string(buf))
+
+	_, ok = n.move(buf, 1)
+	assert.False(t, ok)
+
+	buf, ok = n.move(buf, -1)
+	assert.True(t, ok)
+	buf, ok = n.move(buf, -1)
+	assert.True(t, ok)
+	assert.Equal(t, "git", string(buf))
+
+	_, ok = n.move(buf, -1)
+	assert.False(t, ok)
+}
+
+func TestHistoryNavigatorKeepsEdits(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	hm.Push("one")
+	hm.Push("two")
+
+	n := newHistoryNavigator(hm, []rune{}, "")
+	buf, _ := n.move([]rune("typed"), 1)
+	assert.Equal(t, "two", string(buf))
+	buf, _ = n.move([]rune("two edited"), 1)
+	assert.Equal(t, "one", string(buf))
+	buf, _ = n.move(buf, -1)
+	assert.Equal(t, "two edited", string(buf))
+	buf, _ = n.move(buf, -1)
+	assert.Equal(t, "typed", string(buf))
+}
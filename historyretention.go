@@ -0,0 +1,423 @@
+package ns
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveBatchSize is the number of dropped entries a basic history collects before writing them to an archive
+const archiveBatchSize = 100
+
+// RetentionPolicy limits a history by the age of its entries and its size, in addition to the number of entries to keep
+type RetentionPolicy struct {
+	// MaxAge drops entries which started longer ago.  Entries without a start time are kept.
+	MaxAge time.Duration
+	// MaxSize drops the oldest entries once the history is larger, in bytes.  A persisted history is limited by the size
+	// of its history file, which is trimmed to three quarters of MaxSize when it is exceeded, otherwise by the combined
+	// length of the commands.  The most recent entry is always kept.
+	MaxSize int64
+	// ArchiveDir, when set, receives the dropped entries in gzip compressed archive files rather than discarding them.
+	// Archives are searched with SearchArchives.  Commands deleted from or replaced in the history are deleted from or
+	// replaced in the archives too, and the archives of a persisted history are re-encrypted when its key is rotated.
+	ArchiveDir string
+}
+
+// SetRetention limits the history by the age of its entries and its size, which applies from the next command added
+func (h *BasicHistoryManager) SetRetention(policy RetentionPolicy) {
+	h.retention = policy
+}
+
+// applyRetention drops the oldest entries beyond the retention policy, returning them
+func (h *BasicHistoryManager) applyRetention(now time.Time) []*HistoryEntry {
+	drop := 0
+	if h.retention.MaxAge > 0 {
+		cutoff := now.Add(-h.retention.MaxAge)
+		for drop < len(h.entries)-1 && !h.entries[drop].StartedAt.IsZero() && h.entries[drop].StartedAt.Before(cutoff) {
+			drop++
+		}
+	}
+	if h.retention.MaxSize > 0 {
+		size := int64(0)
+		for _, entry := range h.entries[drop:] {
+			size += int64(len(entry.Command) + 1)
+		}
+		for ; drop < len(h.entries)-1 && size > h.retention.MaxSize; drop++ {
+			size -= int64(len(h.entries[drop].Command) + 1)
+		}
+	}
+	if drop == 0 {
+		return nil
+	}
+
+	dropped := make([]*HistoryEntry, drop)
+	copy(dropped, h.entries)
+	for i := 0; i < drop; i++ {
+		h.index.Pop()
+	}
+	h.entries = h.entries[drop:]
+
+	return dropped
+}
+
+// archive collects dropped entries, writing them to an archive in batches when an archive directory is configured
+func (h *BasicHistoryManager) archive(entries []*HistoryEntry) {
+	if h.retention.ArchiveDir == "" || len(entries) == 0 {
+		return
+	}
+
+	h.archivePending = append(h.archivePending, entries...)
+	if len(h.archivePending) >= archiveBatchSize {
+		h.flushArchive()
+	}
+}
+
+// flushArchive writes the collected dropped entries to an archive
+func (h *BasicHistoryManager) flushArchive() {
+	if len(h.archivePending) == 0 {
+		return
+	}
+
+	codec, _ := newHistoryCodec(HistoryFormatJSONLines, nil, nil)
+	err := writeHistoryArchive(h.retention.ArchiveDir, codec, h.archivePending)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	h.archivePending = nil
+}
+
+// SearchArchives returns the archived entries containing the pattern (case insensitive), from most recent to oldest,
+// including those not yet written to an archive
+func (h *BasicHistoryManager) SearchArchives(pattern string) ([]*HistoryEntry, error) {
+	if h.retention.ArchiveDir == "" || len(pattern) == 0 {
+		return nil, nil
+	}
+
+	found, err := searchHistoryArchives(h.retention.ArchiveDir, plainArchiveCodecs(), pattern)
+	if err != nil {
+		return nil, err
+	}
+	pattern = strings.ToLower(pattern)
+	for _, entry := range h.archivePending {
+		if strings.Contains(strings.ToLower(entry.Command), pattern) {
+			found = append(found, entry)
+		}
+	}
+	sortEntriesRecentFirst(found)
+
+	return found, nil
+}
+
+// SearchArchives returns the archived entries containing the pattern (case insensitive), from most recent to oldest
+func (pm *PersistedHistoryManager) SearchArchives(pattern string) ([]*HistoryEntry, error) {
+	if pm.config.Retention.ArchiveDir == "" || len(pattern) == 0 {
+		return nil, nil
+	}
+
+	pm.fileLock.Lock()
+	defer pm.fileLock.Unlock()
+
+	found, err := searchHistoryArchives(pm.config.Retention.ArchiveDir, pm.archiveCodecs(), pattern)
+	if err != nil {
+		return nil, err
+	}
+	sortEntriesRecentFirst(found)
+
+	return found, nil
+}
+
+// archiveCodecs returns the codecs with which the archives of the history are decoded.  The file lock must be held.
+func (pm *PersistedHistoryManager) archiveCodecs() func(*historyHeader) (*historyCodec, error) {
+	return archiveCodecs(pm.codec, pm.config.Format, pm.config.Encryption)
+}
+
+// exceedsMaxSize returns true if the history file is larger than the retention policy allows
+func (pm *PersistedHistoryManager) exceedsMaxSize() bool {
+	if pm.config.Retention.MaxSize <= 0 || pm.readOnly.Load() {
+		return false
+	}
+
+	info, err := os.Stat(pm.filename)
+
+	return err == nil && info.Size() > pm.config.Retention.MaxSize
+}
+
+// trimToSize drops the oldest entries until the history file written with the remaining entries would be no larger than
+// three quarters of the maximum size, leaving headroom for the commands which follow.  The file lock must be held.
+func (pm *PersistedHistoryManager) trimToSize(entries []*HistoryEntry) ([]*HistoryEntry, error) {
+	if pm.config.Retention.MaxSize <= 0 {
+		return entries, nil
+	}
+
+	target := pm.config.Retention.MaxSize * 3 / 4
+	sizes := make([]int64, len(entries))
+	size := int64(0)
+	if header := pm.codec.header(); header != "" {
+		size += int64(len(header) + 1)
+	}
+	for i, entry := range entries {
+		record, err := pm.codec.encode(entry)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = int64(len(record) + 1)
+		size += sizes[i]
+	}
+
+	drop := 0
+	for ; drop < len(entries)-1 && size > target; drop++ {
+		size -= sizes[drop]
+	}
+
+	return entries[drop:], nil
+}
+
+// archiveDropped writes the entries of the history file which aren't kept to an archive, when an archive directory is
+// configured.  The file lock must be held.
+func (pm *PersistedHistoryManager) archiveDropped(entries []*HistoryEntry, kept []*HistoryEntry) error {
+	if pm.config.Retention.ArchiveDir == "" {
+		return nil
+	}
+
+	keep := make(map[*HistoryEntry]struct{}, len(kept))
+	for _, entry := range kept {
+		keep[entry] = struct{}{}
+	}
+	dropped := []*HistoryEntry{}
+	for _, entry := range entries {
+		if _, ok := keep[entry]; !ok {
+			dropped = append(dropped, entry)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	return writeHistoryArchive(pm.config.Retention.ArchiveDir, pm.codec, dropped)
+}
+
+// archiveCodecs returns the codec with which to decode each archive, given its header (nil if it has none).  Archives
+// encrypted with a passphrase derived key are decoded with the salt in their own header.
+func archiveCodecs(codec *historyCodec, format HistoryFormat, encryption *HistoryEncryption) func(*historyHeader) (*historyCodec, error) {
+	return func(header *historyHeader) (*historyCodec, error) {
+		if header == nil || len(header.Salt) == 0 || bytes.Equal(header.Salt, codec.salt) || encryption == nil {
+			return codec, nil
+		}
+
+		return newHistoryCodec(format, encryption, header.Salt)
+	}
+}
+
+// plainArchiveCodecs decodes the unencrypted archives of a basic history
+func plainArchiveCodecs() func(*historyHeader) (*historyCodec, error) {
+	codec, _ := newHistoryCodec(HistoryFormatJSONLines, nil, nil)
+	return archiveCodecs(codec, HistoryFormatJSONLines, nil)
+}
+
+// encodeHistoryArchive encodes the entries with the codec, and compresses them
+func encodeHistoryArchive(codec *historyCodec, entries []*HistoryEntry) ([]byte, error) {
+	var data bytes.Buffer
+	zw := gzip.NewWriter(&data)
+	if header := codec.header(); header != "" {
+		zw.Write([]byte(header + "\n"))
+	}
+	for _, entry := range entries {
+		record, err := codec.encode(entry)
+		if err != nil {
+			return nil, err
+		}
+		zw.Write([]byte(record + "\n"))
+	}
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Bytes(), nil
+}
+
+// writeHistoryArchive writes the entries to a new gzip compressed archive in the directory, encoded with the codec
+func writeHistoryArchive(dir string, codec *historyCodec, entries []*HistoryEntry) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeHistoryArchive(codec, entries)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("history-%s.gz", time.Now().UTC().Format("20060102T150405.000000000"))
+	return writeFileAtomic(filepath.Join(dir, name), data)
+}
+
+// historyArchives returns the archive files in the directory, from oldest to most recent
+func historyArchives(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "history-*.gz"))
+}
+
+// searchHistoryArchives returns the entries of every archive in the directory which contain the pattern (case
+// insensitive), in no particular order
+func searchHistoryArchives(dir string, codecs func(*historyHeader) (*historyCodec, error), pattern string) ([]*HistoryEntry, error) {
+	filenames, err := historyArchives(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern = strings.ToLower(pattern)
+	found := []*HistoryEntry{}
+	for _, filename := range filenames {
+		entries, err := readHistoryArchive(filename, codecs)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.Contains(strings.ToLower(entry.Command), pattern) {
+				found = append(found, entry)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// rewriteHistoryArchives rewrites every archive in the directory with the codec, editing the entries with the supplied
+// function.  Archives are only rewritten if they were edited, or if rewriteAll is set, and removed if no entries remain.
+func rewriteHistoryArchives(dir string, codecs func(*historyHeader) (*historyCodec, error), codec *historyCodec, rewriteAll bool, edit func([]*HistoryEntry) ([]*HistoryEntry, bool)) error {
+	filenames, err := historyArchives(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		entries, err := readHistoryArchive(filename, codecs)
+		if err != nil {
+			return err
+		}
+		entries, changed := edit(entries)
+		if !changed && !rewriteAll {
+			continue
+		}
+		if len(entries) == 0 {
+			err = os.Remove(filename)
+		} else {
+			var data []byte
+			data, err = encodeHistoryArchive(codec, entries)
+			if err == nil {
+				err = writeFileAtomic(filename, data)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readHistoryArchive reads the entries of an archive, skipping any which can't be decoded
+func readHistoryArchive(filename string, codecs func(*historyHeader) (*historyCodec, error)) ([]*HistoryEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	defer zr.Close()
+
+	entries := []*HistoryEntry{}
+	var codec *historyCodec
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if codec == nil {
+			header, hasHeader, _ := decodeHistoryHeader(line)
+			if !hasHeader {
+				header = nil
+			}
+			codec, err = codecs(header)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			if hasHeader {
+				continue
+			}
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry, err := codec.decode(line)
+		if errors.Is(err, ErrWrongHistoryKey) || errors.Is(err, ErrHistoryEncrypted) {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return entries, nil
+}
+
+// editHistoryEntries applies the edits to the entries, returning the edited entries and whether any were changed
+func editHistoryEntries(entries []*HistoryEntry, edits []historyEdit) ([]*HistoryEntry, bool) {
+	changed := false
+	for _, edit := range edits {
+		edited := make([]*HistoryEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Command != edit.command {
+				edited = append(edited, entry)
+				continue
+			}
+			changed = true
+			if edit.replacement != "" {
+				entry.Command = edit.replacement
+				edited = append(edited, entry)
+			}
+		}
+		entries = edited
+	}
+
+	return entries, changed
+}
+
+// editArchives removes the command from the dropped entries not yet archived, and from the archives, or replaces it
+func (h *BasicHistoryManager) editArchives(command string, replacement string) {
+	if h.retention.ArchiveDir == "" {
+		return
+	}
+
+	edits := []historyEdit{{command: command, replacement: replacement}}
+	h.archivePending, _ = editHistoryEntries(h.archivePending, edits)
+	codec, _ := newHistoryCodec(HistoryFormatJSONLines, nil, nil)
+	err := rewriteHistoryArchives(h.retention.ArchiveDir, plainArchiveCodecs(), codec, false, func(entries []*HistoryEntry) ([]*HistoryEntry, bool) {
+		return editHistoryEntries(entries, edits)
+	})
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func sortEntriesRecentFirst(entries []*HistoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedAt.After(entries[j].StartedAt)
+	})
+}
@@ -0,0 +1,154 @@
+package ns
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicHistoryRetention(t *testing.T) {
+	now := time.Now()
+	archiveDir := t.TempDir()
+	h := NewBasicHistoryManager(100)
+	h.SetRetention(RetentionPolicy{MaxAge: 24 * time.Hour, MaxSize: 12, ArchiveDir: archiveDir})
+	h.PushEntry(&HistoryEntry{Command: "old", StartedAt: now.Add(-48 * time.Hour)})
+	h.PushEntry(&HistoryEntry{Command: "ls", StartedAt: now})
+	assert.Equal(t, []string{"ls"}, commandsOf(h.Entries()))
+
+	h.PushEntry(&HistoryEntry{Command: "pwd", StartedAt: now})
+	h.PushEntry(&HistoryEntry{Command: "whoami", StartedAt: now})
+	assert.Equal(t, []string{"pwd", "whoami"}, commandsOf(h.Entries()))
+	assert.Equal(t, "whoami", h.GetIterator().Backward())
+
+	// dropped entries are searchable before and after they are written to an archive
+	found, err := h.SearchArchives("l")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ls", "old"}, commandsOf(found))
+
+	h.Exit()
+	files, err := filepath.Glob(filepath.Join(archiveDir, "history-*.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+	found, err = h.SearchArchives("OLD")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old"}, commandsOf(found))
+}
+
+func TestPersistedHistoryRetention(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:  100,
+		Filename: filename,
+		Retention: RetentionPolicy{
+			MaxAge:     24 * time.Hour,
+			MaxSize:    400,
+			ArchiveDir: filepath.Join(dir, "archive"),
+		},
+	}
+
+	pm := NewPersistedHistoryManagerWithConfig(config)
+	pm.PushEntry(&HistoryEntry{Command: "old", StartedAt: now.Add(-48 * time.Hour)})
+	for _, cmd := range []string{"cmd 1", "cmd 2", "cmd 3", "cmd 4", "cmd 5", "cmd 6", "cmd 7", "cmd 8"} {
+		pm.PushEntry(&HistoryEntry{Command: cmd, StartedAt: now})
+	}
+	assert.Equal(t, 8, len(pm.Entries()))
+	assert.NoError(t, pm.flushChanges())
+	assert.True(t, pm.exceedsMaxSize())
+	assert.NoError(t, pm.compact())
+	pm.Exit()
+
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), config.Retention.MaxSize*3/4)
+
+	pm = NewPersistedHistoryManagerWithConfig(config)
+	defer pm.Exit()
+	entries := pm.Entries()
+	assert.Less(t, len(entries), 8)
+	assert.Equal(t, "cmd 8", entries[len(entries)-1].Command)
+
+	found, err := pm.SearchArchives("old")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old"}, commandsOf(found))
+	found, err = pm.SearchArchives("cmd 1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cmd 1"}, commandsOf(found))
+}
+
+func TestHistoryArchiveEdit(t *testing.T) {
+	archiveDir := t.TempDir()
+	h := NewBasicHistoryManager(1)
+	h.SetRetention(RetentionPolicy{ArchiveDir: archiveDir})
+	h.Push("login hunter2")
+	h.Push("ls")
+	h.flushArchive()
+	h.Push("login hunter2")
+	h.Push("pwd")
+
+	// both the archive and the entry not yet archived are edited
+	assert.Equal(t, 0, h.Delete("login hunter2"))
+	found, err := h.SearchArchives("hunter2")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+	h.Exit()
+	found, err = h.SearchArchives("hunter2")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+	files, err := filepath.Glob(filepath.Join(archiveDir, "history-*.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:   1,
+		Filename:  filename,
+		Retention: RetentionPolicy{ArchiveDir: filepath.Join(t.TempDir(), "archive")},
+	}
+	pm := NewPersistedHistoryManagerWithConfig(config)
+	pm.Push("login hunter2")
+	pm.Push("ls")
+	assert.NoError(t, pm.compact())
+	pm.Replace("login hunter2", "login ********")
+	pm.Exit()
+
+	pm = NewPersistedHistoryManagerWithConfig(config)
+	defer pm.Exit()
+	found, err = pm.SearchArchives("login")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login ********"}, commandsOf(found))
+}
+
+func TestEncryptedHistoryArchiveRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:   1,
+		Filename:  filename,
+		Retention: RetentionPolicy{ArchiveDir: filepath.Join(t.TempDir(), "archive")},
+	}
+	pm, err := NewEncryptedHistoryManager(config, HistoryEncryption{Key: bytes.Repeat([]byte{1}, 32)})
+	assert.NoError(t, err)
+	pm.Push("make deploy")
+	pm.Push("ls")
+	assert.NoError(t, pm.compact())
+
+	assert.NoError(t, pm.RotateKey(bytes.Repeat([]byte{2}, 32)))
+	found, err := pm.SearchArchives("deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"make deploy"}, commandsOf(found))
+
+	assert.NoError(t, pm.RotatePassphrase("correct horse"))
+	pm.Exit()
+
+	pm, err = NewEncryptedHistoryManager(config, HistoryEncryption{Passphrase: "correct horse"})
+	assert.NoError(t, err)
+	defer pm.Exit()
+	found, err = pm.SearchArchives("deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"make deploy"}, commandsOf(found))
+}
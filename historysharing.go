@@ -0,0 +1,180 @@
+package ns
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// historyEntryKey identifies an entry across processes
+func historyEntryKey(entry *HistoryEntry) string {
+	return fmt.Sprintf("%s\x00%d\x00%s", entry.SessionID, entry.StartedAt.UnixNano(), entry.Command)
+}
+
+// updateFileOffset records the current end of the history file as the position from which commands appended by other
+// processes are read.  The file lock must be held.
+func (pm *PersistedHistoryManager) updateFileOffset() {
+	info, err := os.Stat(pm.filename)
+	if err != nil {
+		return
+	}
+
+	pm.fileInfo = info
+	pm.fileOffset = info.Size()
+}
+
+// pollShared reads any commands appended to the history file by other processes
+func (pm *PersistedHistoryManager) pollShared() error {
+	if pm.readOnly.Load() {
+		return nil
+	}
+
+	pm.fileLock.Lock()
+	defer pm.fileLock.Unlock()
+
+	return pm.readShared()
+}
+
+// readShared reads the commands appended to the history file since it was last read, and stages them to be merged into
+// the history.  If the file has been replaced or truncated, it is read from the beginning and only previously unseen
+// entries are staged.  The file lock must be held.
+func (pm *PersistedHistoryManager) readShared() error {
+	info, err := os.Stat(pm.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	start := pm.fileOffset
+	if pm.fileInfo == nil || !os.SameFile(pm.fileInfo, info) || info.Size() < start {
+		start = 0
+	}
+	if info.Size() == start {
+		pm.fileInfo = info
+		return nil
+	}
+
+	f, err := os.Open(pm.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, info.Size()-start))
+	if err != nil {
+		return err
+	}
+
+	// only complete lines are consumed, a partially written line is read once it is complete
+	consumed := strings.LastIndex(string(data), "\n") + 1
+	lines := strings.Split(string(data[:consumed]), "\n")
+	if start == 0 && len(lines) > 0 {
+		if _, hasHeader, _ := decodeHistoryHeader(lines[0]); hasHeader {
+			lines = lines[1:]
+		}
+	}
+
+	entries := []*HistoryEntry{}
+	pm.flushLock.Lock()
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entry, err := pm.codec.decode(line)
+		if err != nil {
+			continue
+		}
+		entry.Command = strings.Trim(entry.Command, "\r\n ")
+		if len(entry.Command) == 0 {
+			continue
+		}
+
+		key := historyEntryKey(entry)
+		if _, ok := pm.known[key]; ok {
+			continue
+		}
+		pm.known[key] = struct{}{}
+		entries = append(entries, entry)
+	}
+	pm.imported = append(pm.imported, entries...)
+	pm.flushLock.Unlock()
+
+	pm.fileInfo = info
+	pm.fileOffset = start + int64(consumed)
+
+	return nil
+}
+
+// mergeShared merges the staged commands from other processes into the history.  It is called from the methods used by
+// the reader, such that the history is only modified from the reader's goroutine.
+func (pm *PersistedHistoryManager) mergeShared() {
+	pm.flushLock.Lock()
+	imported := pm.imported
+	pm.imported = nil
+	pm.flushLock.Unlock()
+
+	// a command from another session isn't this session's previous command, so repeating it after it is imported isn't
+	// skipped as a repeat
+	for _, entry := range imported {
+		pm.BasicHistoryManager.recordUsage(entry)
+		pm.BasicHistoryManager.insertEntry(entry)
+	}
+}
+
+// GetIterator returns an iterator over the history.  When sharing history, this session's commands are visited first,
+// followed by those of other sessions.
+func (pm *PersistedHistoryManager) GetIterator() HistoryIterator {
+	pm.mergeShared()
+	if !pm.config.ShareHistory {
+		return pm.BasicHistoryManager.GetIterator()
+	}
+
+	pm.flushLock.Lock()
+	defer pm.flushLock.Unlock()
+
+	entries := pm.BasicHistoryManager.entries
+	seen := map[string]struct{}{}
+	local := []string{}
+	others := []string{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if _, ok := pm.local[entries[i]]; ok {
+			local = append(local, entries[i].Command)
+		} else {
+			others = append(others, entries[i].Command)
+		}
+	}
+
+	commands := []string{}
+	for _, command := range append(local, others...) {
+		if _, ok := seen[command]; ok {
+			continue
+		}
+		seen[command] = struct{}{}
+		commands = append(commands, command)
+	}
+
+	return NewSliceHistoryIterator(commands)
+}
+
+func (pm *PersistedHistoryManager) Search(pattern string) []string {
+	pm.mergeShared()
+	return pm.BasicHistoryManager.Search(pattern)
+}
+
+func (pm *PersistedHistoryManager) Entries() []*HistoryEntry {
+	pm.mergeShared()
+	return pm.BasicHistoryManager.Entries()
+}
+
+func (pm *PersistedHistoryManager) SearchEntries(pattern string) []*HistoryEntry {
+	pm.mergeShared()
+	return pm.BasicHistoryManager.SearchEntries(pattern)
+}
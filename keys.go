@@ -5,16 +5,25 @@ const (
 	KEY_CTRL_D      = "\x04" // Signal EOF
 	KEY_CTRL_L      = "\x0C" // Clear terminal
 	KEY_TAB         = "\x09"
+	KEY_SHIFT_TAB   = "\x1B[Z" // Previous snippet placeholder
 	KEY_ENTER       = "\x0D"
 	KEY_CTRL_R      = "\x12" // Search backward
+	KEY_CTRL_S      = "\x13" // Search forward
 	KEY_CTRL_T      = "\x14"
 	KEY_ESCAPE      = "\x1B"
 	KEY_BACKSPACE   = "\x7F"
 	KEY_DEL         = "\x1B[3~"
+	KEY_SHIFT_DEL   = "\x1B[3;2~" // Delete the selected history entry
 	KEY_END         = "\x1B[F"
 	KEY_HOME        = "\x1B[H"
 	KEY_UP_ARROW    = "\x1B[A"
 	KEY_DOWN_ARROW  = "\x1B[B"
 	KEY_RIGHT_ARROW = "\x1B[C"
 	KEY_LEFT_ARROW  = "\x1B[D"
+	KEY_F1          = "\x1BOP" // Contextual help
+	KEY_F1_ALT      = "\x1B[11~"
+	KEY_ALT_H       = "\x1Bh" // Contextual help
+	KEY_ALT_R       = "\x1Br" // History picker
+	KEY_ALT_E       = "\x1Be" // Edit a history entry and run it
+	KEY_ESC_Q       = "\x1Bq" // Push the current line, restoring it after the next command
 )
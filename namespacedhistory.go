@@ -0,0 +1,145 @@
+package ns
+
+import (
+	"sync"
+	"time"
+)
+
+// NamespacedHistory is a view of a history manager restricted to a single namespace, such as a working directory, a
+// connected target, or a sub-mode of the application.  Commands are recorded in the active namespace, and navigated and
+// searched only within it, so that several namespaces can share one history manager (and file).  The namespace can be
+// switched at any time.  With the erase duplicates mode, a repeated command erases its occurrences in every namespace.
+type NamespacedHistory struct {
+	hm        EntryHistoryManager
+	lock      sync.Mutex
+	namespace string
+	// searchGlobal appends the matches from other namespaces to the search results of the active namespace
+	searchGlobal bool
+}
+
+// NewNamespacedHistory creates a view of the history manager restricted to the namespace.  When searchGlobal is set,
+// searches fall back to the history of every namespace, after the matches in the active namespace.
+func NewNamespacedHistory(hm EntryHistoryManager, namespace string, searchGlobal bool) *NamespacedHistory {
+	return &NamespacedHistory{
+		hm:           hm,
+		namespace:    namespace,
+		searchGlobal: searchGlobal,
+	}
+}
+
+// SetNamespace switches the active namespace
+func (nh *NamespacedHistory) SetNamespace(namespace string) {
+	nh.lock.Lock()
+	defer nh.lock.Unlock()
+
+	nh.namespace = namespace
+}
+
+// Namespace returns the active namespace
+func (nh *NamespacedHistory) Namespace() string {
+	nh.lock.Lock()
+	defer nh.lock.Unlock()
+
+	return nh.namespace
+}
+
+// SetSearchGlobal sets whether searches fall back to the history of every namespace
+func (nh *NamespacedHistory) SetSearchGlobal(searchGlobal bool) {
+	nh.lock.Lock()
+	defer nh.lock.Unlock()
+
+	nh.searchGlobal = searchGlobal
+}
+
+// Manager returns the underlying history manager, which holds every namespace
+func (nh *NamespacedHistory) Manager() EntryHistoryManager {
+	return nh.hm
+}
+
+func (nh *NamespacedHistory) Push(value string) {
+	nh.PushEntry(&HistoryEntry{
+		Command:   value,
+		StartedAt: time.Now(),
+	})
+}
+
+// PushEntry records the entry in the active namespace
+func (nh *NamespacedHistory) PushEntry(entry *HistoryEntry) {
+	entry.Namespace = nh.Namespace()
+	nh.hm.PushEntry(entry)
+}
+
+// Entries returns the entries of the active namespace, from oldest to most recent
+func (nh *NamespacedHistory) Entries() []*HistoryEntry {
+	namespace := nh.Namespace()
+	entries := []*HistoryEntry{}
+	for _, entry := range nh.hm.Entries() {
+		if entry.Namespace == namespace {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// SearchEntries returns the entries containing the pattern, from most recent to oldest.  Those of the active namespace
+// come first, followed by the others when searching globally.
+func (nh *NamespacedHistory) SearchEntries(pattern string) []*HistoryEntry {
+	nh.lock.Lock()
+	namespace := nh.namespace
+	searchGlobal := nh.searchGlobal
+	nh.lock.Unlock()
+
+	found := nh.hm.SearchEntries(pattern)
+	entries := []*HistoryEntry{}
+	others := []*HistoryEntry{}
+	for _, entry := range found {
+		if entry.Namespace == namespace {
+			entries = append(entries, entry)
+		} else if searchGlobal {
+			others = append(others, entry)
+		}
+	}
+
+	return append(entries, others...)
+}
+
+// Search returns the distinct commands containing the pattern, ordered as SearchEntries
+func (nh *NamespacedHistory) Search(pattern string) []string {
+	entries := nh.SearchEntries(pattern)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	seen := map[string]struct{}{}
+	commands := []string{}
+	for _, entry := range entries {
+		if _, ok := seen[entry.Command]; ok {
+			continue
+		}
+		seen[entry.Command] = struct{}{}
+		commands = append(commands, entry.Command)
+	}
+
+	return commands
+}
+
+// GetIterator iterates over the commands of the active namespace, from most recent to oldest
+func (nh *NamespacedHistory) GetIterator() HistoryIterator {
+	entries := nh.Entries()
+	commands := []string{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		// commands from other namespaces no longer separate repeats of the same command
+		if len(commands) > 0 && commands[len(commands)-1] == entries[i].Command {
+			continue
+		}
+		commands = append(commands, entries[i].Command)
+	}
+
+	return NewSliceHistoryIterator(commands)
+}
+
+// Exit exits the underlying history manager
+func (nh *NamespacedHistory) Exit() {
+	nh.hm.Exit()
+}
@@ -0,0 +1,60 @@
+package ns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespacedHistory(t *testing.T) {
+	nh := NewNamespacedHistory(NewBasicHistoryManager(100), "prod", false)
+	nh.Push("deploy api")
+	nh.Push("ls")
+	nh.SetNamespace("staging")
+	nh.Push("ls")
+	nh.Push("deploy web")
+
+	// the repeated command is recorded in each namespace
+	assert.Equal(t, []string{"ls", "deploy web"}, commandsOf(nh.Entries()))
+	assert.Equal(t, []string{"deploy web"}, nh.Search("deploy"))
+
+	iter := nh.GetIterator()
+	assert.Equal(t, "deploy web", iter.Backward())
+	assert.Equal(t, "ls", iter.Backward())
+	assert.Equal(t, "ls", iter.Backward())
+
+	nh.SetSearchGlobal(true)
+	assert.Equal(t, []string{"deploy web", "deploy api"}, nh.Search("deploy"))
+
+	nh.SetNamespace("prod")
+	assert.Equal(t, []string{"deploy api", "deploy web"}, nh.Search("deploy"))
+	assert.Equal(t, []string{"deploy api", "ls"}, commandsOf(nh.Entries()))
+}
+
+func TestNamespacedPersistedHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+
+	nh := NewNamespacedHistory(NewPersistedHistoryManager(100, filename), "/srv", false)
+	nh.Push("make")
+	nh.SetNamespace("/home")
+	nh.Push("ls")
+	nh.Exit()
+
+	nh = NewNamespacedHistory(NewPersistedHistoryManager(100, filename), "/srv", false)
+	defer nh.Exit()
+	assert.Equal(t, []string{"make"}, commandsOf(nh.Entries()))
+	assert.Equal(t, 2, len(nh.Manager().Entries()))
+}
+
+func TestReaderHistoryNamespace(t *testing.T) {
+	r := NewReader(ReaderConfig{})
+	assert.True(t, r.SetHistoryNamespace("a"))
+	nh, ok := r.HistoryManager().(*NamespacedHistory)
+	assert.True(t, ok)
+	assert.Equal(t, "a", nh.Namespace())
+
+	assert.True(t, r.SetHistoryNamespace("b"))
+	assert.Equal(t, nh, r.HistoryManager())
+	assert.Equal(t, "b", nh.Namespace())
+}
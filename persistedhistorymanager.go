@@ -1,34 +1,111 @@
 package ns
 
 import (
-	"encoding/base64"
+	"bufio"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// WritePolicy determines when the commands pushed to a persisted history are written to the history file
+type WritePolicy int
+
+const (
+	// WriteInterval writes pending commands every FlushInterval, leaving it to the operating system to sync the file
+	WriteInterval WritePolicy = iota
+	// WriteBatched writes and syncs pending commands every FlushInterval, or as soon as BatchSize commands are pending
+	WriteBatched
+	// WriteImmediate writes and syncs each command before PushEntry returns
+	WriteImmediate
+)
+
+// PersistedHistoryConfig configures a PersistedHistoryManager
+type PersistedHistoryConfig struct {
+	MaxKeep  int
+	Filename string
+	// Format is the format in which the history file is written.  Files in any supported format are read, and converted
+	// to this format when loaded.
+	Format HistoryFormat
+	// OnError is called with errors which occur while loading or writing the history file, such as corrupted lines.  Errors
+	// are logged when unset.  Corrupted lines are moved to the file named by Filename with .corrupt appended when the
+	// history file is rewritten.
+	OnError func(error)
+	// ShareHistory incrementally reads the commands which other processes append to the history file, making them
+	// available to this session without a restart.  Up and down navigation continues to favor this session's own
+	// commands, followed by those of other sessions.
+	ShareHistory bool
+	// SharePollInterval is how often the history file is checked for commands from other processes (default 1 second)
+	SharePollInterval time.Duration
+	// DedupMode determines how repeated commands are handled
+	DedupMode DedupMode
+	// CompactInterval is how often the history file is compacted in the background, removing the records which have been
+	// dropped from the history (default 5 minutes)
+	CompactInterval time.Duration
+	// Encryption encrypts each record of the history file, Format is ignored when set
+	Encryption *HistoryEncryption
+	// RankMode determines the order of search results.  With RankFrecency the use counts of commands are kept in a
+	// separate file alongside the history file.
+	RankMode RankMode
+	// WritePolicy determines when commands are written to the history file, and whether it is synced
+	WritePolicy WritePolicy
+	// FlushInterval is how often pending commands are written (default 5 seconds)
+	FlushInterval time.Duration
+	// BatchSize is the number of pending commands which are written at once with WriteBatched (default 10)
+	BatchSize int
+	// Retention limits the history by the age of its entries and the size of the history file, optionally archiving the
+	// dropped entries when the file is compacted
+	Retention RetentionPolicy
+}
+
 type PersistedHistoryManager struct {
 	*BasicHistoryManager
+	config    PersistedHistoryConfig
 	filename  string
 	fileLock  *FileLock
 	wg        sync.WaitGroup
 	killChan  chan struct{}
-	unwritten []string
-	flushLock sync.Mutex
+	flushChan chan struct{}
+	unwritten []*HistoryEntry
+	// pendingEdits holds the edits not yet made to the history file, protected by the flush lock
+	pendingEdits []historyEdit
+	// pendingUsage holds the use counts not yet added to the usage file with RankFrecency, protected by the flush lock
+	pendingUsage map[string]*CommandUsage
+	flushLock    sync.Mutex
+	// readOnly is set when the file can't safely be written, which the flush thread may discover while reading it
+	readOnly   atomic.Bool
+	compactDue bool
+	// codec is protected by the file lock, which excludes the flush thread as well as other processes
+	codec *historyCodec
+	// reported holds the corrupt lines already passed to OnError, which are reported once however often the file is read.
+	// It is protected by the file lock.
+	reported map[string]struct{}
+
+	// shared history state, the file offset and info are protected by the file lock, the rest by the flush lock
+	fileOffset int64
+	fileInfo   os.FileInfo
+	known      map[string]struct{}
+	local      map[*HistoryEntry]struct{}
+	imported   []*HistoryEntry
 }
 
 func NewPersistedHistoryManager(maxKeep int, filename string) *PersistedHistoryManager {
+	return NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:  maxKeep,
+		Filename: filename,
+	})
+}
 
-	pm := &PersistedHistoryManager{
-		BasicHistoryManager: NewBasicHistoryManager(maxKeep),
-		filename:            filename,
-		fileLock:            NewFileLock(fmt.Sprintf("%s.lock", filename)),
-		killChan:            make(chan struct{}, 1),
+func NewPersistedHistoryManagerWithConfig(config PersistedHistoryConfig) *PersistedHistoryManager {
+	pm, err := newPersistedHistoryManager(config)
+	if err != nil {
+		pm.config.OnError(err)
 	}
-	pm.load()
 
 	pm.wg.Add(1)
 	go pm.flushThread()
@@ -36,15 +113,107 @@ func NewPersistedHistoryManager(maxKeep int, filename string) *PersistedHistoryM
 	return pm
 }
 
-func (pm *PersistedHistoryManager) Push(value string) {
-	if value == pm.BasicHistoryManager.prev {
-		return
+// NewEncryptedHistoryManager creates a persisted history manager which encrypts each record of the history file.
+// Unlike the other constructors, an error is returned if the history can't be loaded, such as ErrWrongHistoryKey when
+// the file was encrypted with a key which isn't configured.
+func NewEncryptedHistoryManager(config PersistedHistoryConfig, encryption HistoryEncryption) (*PersistedHistoryManager, error) {
+	config.Encryption = &encryption
+	pm, err := newPersistedHistoryManager(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.wg.Add(1)
+	go pm.flushThread()
+
+	return pm, nil
+}
+
+func newPersistedHistoryManager(config PersistedHistoryConfig) (*PersistedHistoryManager, error) {
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			slog.Error(err.Error())
+		}
+	}
+
+	if config.SharePollInterval <= 0 {
+		config.SharePollInterval = time.Second
+	}
+
+	if config.CompactInterval <= 0 {
+		config.CompactInterval = 5 * time.Minute
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
 	}
 
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+	}
+
+	pm := &PersistedHistoryManager{
+		BasicHistoryManager: NewBasicHistoryManager(config.MaxKeep),
+		config:              config,
+		filename:            config.Filename,
+		fileLock:            NewFileLock(fmt.Sprintf("%s.lock", config.Filename)),
+		killChan:            make(chan struct{}, 1),
+		flushChan:           make(chan struct{}, 1),
+		known:               map[string]struct{}{},
+		local:               map[*HistoryEntry]struct{}{},
+		pendingUsage:        map[string]*CommandUsage{},
+	}
+	pm.BasicHistoryManager.SetDedupMode(config.DedupMode)
+	pm.BasicHistoryManager.SetRankMode(config.RankMode)
+	// the size of the history file is enforced, and dropped entries archived, when the file is compacted
+	pm.BasicHistoryManager.SetRetention(RetentionPolicy{MaxAge: config.Retention.MaxAge})
+
+	return pm, pm.load()
+}
+
+func (pm *PersistedHistoryManager) Push(value string) {
+	pm.PushEntry(&HistoryEntry{
+		Command:   value,
+		StartedAt: time.Now(),
+	})
+}
+
+// PushEntry adds an entry to the history, unless it repeats the previous command, and writes it according to the write
+// policy
+func (pm *PersistedHistoryManager) PushEntry(entry *HistoryEntry) {
+	pm.mergeShared()
+
 	pm.flushLock.Lock()
-	defer pm.flushLock.Unlock()
-	pm.BasicHistoryManager.Push(value)
-	pm.unwritten = append(pm.unwritten, base64.StdEncoding.EncodeToString([]byte(value))+"\n")
+	if pm.config.RankMode == RankFrecency {
+		pm.addPendingUsage(entry)
+	}
+	if pm.BasicHistoryManager.repeatsPrevious(entry) {
+		pm.BasicHistoryManager.recordUsage(entry)
+	} else {
+		pm.BasicHistoryManager.PushEntry(entry)
+		if pm.config.ShareHistory {
+			pm.local[entry] = struct{}{}
+			pm.known[historyEntryKey(entry)] = struct{}{}
+		}
+		pm.unwritten = append(pm.unwritten, entry)
+	}
+	pending := len(pm.unwritten)
+	pm.flushLock.Unlock()
+
+	switch pm.config.WritePolicy {
+	case WriteImmediate:
+		err := pm.flushChanges()
+		if err != nil {
+			pm.config.OnError(err)
+		}
+	case WriteBatched:
+		if pending >= pm.config.BatchSize {
+			select {
+			case pm.flushChan <- struct{}{}:
+			default:
+			}
+		}
+	}
 }
 
 func (pm *PersistedHistoryManager) Exit() {
@@ -56,86 +225,582 @@ func (pm *PersistedHistoryManager) Exit() {
 func (pm *PersistedHistoryManager) flushThread() {
 	defer pm.wg.Done()
 
-	ticker := time.NewTicker(time.Second * 5)
+	if pm.compactDue {
+		err := pm.compact()
+		if err != nil {
+			pm.config.OnError(err)
+		}
+	}
+
+	ticker := time.NewTicker(pm.config.FlushInterval)
+	defer ticker.Stop()
+	compactTicker := time.NewTicker(pm.config.CompactInterval)
+	defer compactTicker.Stop()
+	var shareChan <-chan time.Time
+	if pm.config.ShareHistory {
+		shareTicker := time.NewTicker(pm.config.SharePollInterval)
+		defer shareTicker.Stop()
+		shareChan = shareTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			err := pm.flushChanges()
+			err := pm.flush()
+			if err != nil {
+				pm.config.OnError(err)
+			}
+		case <-pm.flushChan:
+			err := pm.flush()
+			if err != nil {
+				pm.config.OnError(err)
+			}
+		case <-compactTicker.C:
+			err := pm.compact()
+			if err != nil {
+				pm.config.OnError(err)
+			}
+		case <-shareChan:
+			err := pm.pollShared()
 			if err != nil {
-				slog.Error(err.Error())
+				pm.config.OnError(err)
 			}
 		case <-pm.killChan:
 			err := pm.flushChanges()
 			if err != nil {
-				slog.Error(err.Error())
+				pm.config.OnError(err)
 			}
 			return
 		}
 	}
 }
 
+// flush writes pending changes, compacting the history file if it has grown beyond its maximum size
+func (pm *PersistedHistoryManager) flush() error {
+	err := pm.flushChanges()
+	if err == nil && pm.exceedsMaxSize() {
+		err = pm.compact()
+	}
+
+	return err
+}
+
 func (pm *PersistedHistoryManager) flushChanges() error {
-	var unwrittenCopy []string
+	var unwrittenCopy []*HistoryEntry
 	pm.flushLock.Lock()
 	if len(pm.unwritten) > 0 {
-		unwrittenCopy = make([]string, len(pm.unwritten))
+		unwrittenCopy = make([]*HistoryEntry, len(pm.unwritten))
 		copy(unwrittenCopy, pm.unwritten)
-		pm.unwritten = []string{}
+		pm.unwritten = []*HistoryEntry{}
 	}
+	pendingUsage := pm.pendingUsage
+	pm.pendingUsage = map[string]*CommandUsage{}
+	pendingEdits := pm.pendingEdits
+	pm.pendingEdits = nil
 	pm.flushLock.Unlock()
 
-	if len(unwrittenCopy) > 0 {
-		pm.fileLock.Lock()
-		defer pm.fileLock.Unlock()
+	if pm.readOnly.Load() || (len(unwrittenCopy) == 0 && len(pendingUsage) == 0 && len(pendingEdits) == 0) {
+		return nil
+	}
+
+	pm.fileLock.Lock()
+	defer pm.fileLock.Unlock()
+
+	// edits are made before appending, so that commands pushed after an edit aren't edited too
+	err := pm.applyEdits(pendingEdits)
+	if err != nil {
+		// keep what couldn't be written for the next flush
+		pm.requeue(unwrittenCopy, pendingUsage, pendingEdits)
+		return err
+	}
+	err = pm.appendRecords(unwrittenCopy)
+	if err != nil {
+		pm.requeue(unwrittenCopy, pendingUsage, nil)
+		return err
+	}
+	if len(pendingUsage) > 0 {
+		err = pm.writeUsage(pendingUsage)
+		if err != nil {
+			pm.requeue(nil, pendingUsage, nil)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeue returns entries, use counts and edits which couldn't be written to those pending
+func (pm *PersistedHistoryManager) requeue(entries []*HistoryEntry, usage map[string]*CommandUsage, edits []historyEdit) {
+	pm.flushLock.Lock()
+	defer pm.flushLock.Unlock()
+
+	pm.unwritten = append(entries, pm.unwritten...)
+	pm.pendingEdits = append(edits, pm.pendingEdits...)
+	for command, delta := range usage {
+		if pending, ok := pm.pendingUsage[command]; ok {
+			delta.Count += pending.Count
+			if pending.LastUsed.After(delta.LastUsed) {
+				delta.LastUsed = pending.LastUsed
+			}
+		}
+		pm.pendingUsage[command] = delta
+	}
+}
 
-		f, err := os.OpenFile(pm.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// appendRecords appends the entries to the history file, syncing it unless writing at intervals.  The file lock must be
+// held.
+func (pm *PersistedHistoryManager) appendRecords(unwrittenCopy []*HistoryEntry) error {
+	if len(unwrittenCopy) == 0 {
+		return nil
+	}
+
+	if pm.config.ShareHistory {
+		// pick up anything appended by other processes, so that only our own records follow the offset
+		err := pm.readShared()
 		if err != nil {
 			return err
 		}
+		defer pm.updateFileOffset()
+	}
 
-		_, err = f.WriteString(strings.Join(unwrittenCopy, ""))
+	// the records are encoded before the file is touched, so nothing is written if any can't be
+	encoded := []string{}
+	for _, entry := range unwrittenCopy {
+		record, err := pm.codec.encode(entry)
 		if err != nil {
 			return err
 		}
+		encoded = append(encoded, record+"\n")
 	}
 
-	return nil
+	f, err := os.OpenFile(pm.filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	records := []string{}
+	if info.Size() == 0 {
+		// a new (or emptied) file begins with the header of the format
+		if header := pm.codec.header(); header != "" {
+			records = append(records, header+"\n")
+		}
+	} else {
+		// never append to an unterminated line, such as one left by a write which was interrupted
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			records = append(records, "\n")
+		}
+	}
+	records = append(records, encoded...)
+
+	_, err = f.WriteString(strings.Join(records, ""))
+	if err == nil && pm.config.WritePolicy != WriteInterval {
+		err = f.Sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
 }
 
 func (pm *PersistedHistoryManager) load() error {
 	pm.fileLock.Lock()
 	defer pm.fileLock.Unlock()
 
+	// the salt of a passphrase derived key is kept in the header
+	var salt []byte
+	if header, err := pm.readHistoryHeader(); err == nil {
+		salt = header.Salt
+	}
+	codec, err := newHistoryCodec(pm.config.Format, pm.config.Encryption, salt)
+	if err != nil {
+		pm.readOnly.Store(true)
+		return err
+	}
+	pm.codec = codec
+
+	file, err := pm.readHistoryFile()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if file.truncated >= 0 || !file.matchesFormat {
+		// the truncated record and any other corrupt lines are gone once the file is truncated or rewritten
+		err = pm.keepCorrupt(file)
+		if err != nil {
+			pm.readOnly.Store(true)
+			return err
+		}
+	}
+	if file.truncated >= 0 {
+		err = os.Truncate(pm.filename, file.truncated)
+		if err != nil {
+			pm.config.OnError(err)
+		}
+	}
+
+	for _, entry := range file.entries {
+		pm.BasicHistoryManager.PushEntry(entry)
+		if pm.config.ShareHistory {
+			pm.known[historyEntryKey(entry)] = struct{}{}
+		}
+	}
+
+	// use counts are only kept in the usage file to rank by frecency, and are derived from the history until they have
+	// been saved.  An existing usage file is migrated with the history file regardless.
+	frecency := pm.config.RankMode == RankFrecency
+	usage, err := pm.readUsage()
+	saveUsage := !file.matchesFormat
+	if err == nil {
+		if frecency {
+			pm.BasicHistoryManager.usage = usage
+		}
+	} else if os.IsNotExist(err) {
+		saveUsage = frecency && (saveUsage || len(file.entries) > 0)
+		usage = pm.BasicHistoryManager.usage
+	} else {
+		pm.config.OnError(err)
+		saveUsage = frecency && saveUsage
+		usage = pm.BasicHistoryManager.usage
+	}
+
+	if saveUsage {
+		err = pm.saveUsage(usage)
+		if err != nil {
+			pm.config.OnError(err)
+		}
+	}
+	if !file.matchesFormat {
+		// rewrite the file in the configured format, which also migrates files from earlier versions
+		err = pm.writeHistoryFile(pm.BasicHistoryManager.entries)
+		if err != nil {
+			return err
+		}
+	} else if len(pm.BasicHistoryManager.entries) < len(file.entries) {
+		pm.compactDue = true
+	}
+	pm.updateFileOffset()
+
+	return nil
+}
+
+// historyFile is the decoded content of a history file
+type historyFile struct {
+	entries []*HistoryEntry
+	// matchesFormat is true if the file, and every record in it, is in the configured format
+	matchesFormat bool
+	// truncated is the length to which the file is truncated to remove a partially written trailing record, or -1 if
+	// there is none
+	truncated int64
+	// corrupt holds the lines which could not be decoded, including a partially written trailing record
+	corrupt []string
+}
+
+// readHistoryHeader reads the header from the first line of the history file.  The file lock must be held.
+func (pm *PersistedHistoryManager) readHistoryHeader() (*historyHeader, error) {
+	f, err := os.Open(pm.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	header, _, err := decodeHistoryHeader(strings.TrimRight(line, "\r\n"))
+
+	return header, err
+}
+
+// readHistoryFile reads and decodes the history file, reporting any corrupted lines.  The file lock must be held.
+func (pm *PersistedHistoryManager) readHistoryFile() (*historyFile, error) {
 	fBytes, err := os.ReadFile(pm.filename)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	fStrs := string(fBytes)
 	fHist := strings.Split(fStrs, "\n")
 
-	for _, enc := range fHist {
-		b, err := base64.StdEncoding.DecodeString(enc)
+	header, hasHeader, err := decodeHistoryHeader(fHist[0])
+	if err != nil {
+		// the file was written by a newer version, leave it untouched
+		pm.readOnly.Store(true)
+		return nil, fmt.Errorf("%s: %w", pm.filename, err)
+	}
+	if header.Cipher != "" && !pm.codec.encrypted() {
+		pm.readOnly.Store(true)
+		return nil, fmt.Errorf("%s: %w", pm.filename, ErrHistoryEncrypted)
+	}
+	if hasHeader {
+		fHist = fHist[1:]
+	}
+
+	file := &historyFile{
+		matchesFormat: pm.codec.matchesHeader(header, hasHeader),
+		truncated:     -1,
+	}
+	for i, enc := range fHist {
+		if len(strings.TrimSpace(enc)) == 0 {
+			continue
+		}
+
+		lineNum := i + 1
+		if hasHeader {
+			lineNum++
+		}
+		entry, err := pm.codec.decode(enc)
+		if err != nil && i == len(fHist)-1 {
+			// an unterminated record which can't be decoded was cut short, most likely by a crash while it was written
+			file.truncated = int64(len(fBytes) - len(enc))
+			file.corrupt = append(file.corrupt, enc)
+			pm.reportLine(enc, &HistoryLineError{Filename: pm.filename, Line: lineNum, Err: ErrHistoryRecordTruncated})
+			break
+		}
+		if errors.Is(err, ErrWrongHistoryKey) || errors.Is(err, ErrHistoryEncrypted) {
+			// rather than load a partial history and later rewrite the file, leave it untouched
+			pm.readOnly.Store(true)
+			return nil, fmt.Errorf("%s: %w", pm.filename, err)
+		}
 		if err != nil {
+			file.corrupt = append(file.corrupt, enc)
+			pm.reportLine(enc, &HistoryLineError{Filename: pm.filename, Line: lineNum, Err: err})
 			continue
 		}
+		if !pm.codec.isCurrent(enc) {
+			file.matchesFormat = false
+		}
 
-		cmd := string(b)
-		cmd = strings.Trim(cmd, "\r\n ")
-		if len(cmd) > 0 {
-			pm.BasicHistoryManager.Push(cmd)
+		entry.Command = strings.Trim(entry.Command, "\r\n ")
+		if len(entry.Command) > 0 {
+			file.entries = append(file.entries, entry)
 		}
 	}
 
-	iter := pm.BasicHistoryManager.index.GetIter()
+	return file, nil
+}
+
+// reportLine passes the error for a corrupt line to OnError, unless it has already been reported.  The file lock must be
+// held.
+func (pm *PersistedHistoryManager) reportLine(line string, err *HistoryLineError) {
+	if pm.reported == nil {
+		pm.reported = map[string]struct{}{}
+	}
+	if _, ok := pm.reported[line]; ok {
+		return
+	}
+	pm.reported[line] = struct{}{}
+	pm.config.OnError(err)
+}
+
+// keepCorrupt appends the lines of the file which could not be decoded to the .corrupt file beside it, so that they
+// aren't lost when the file is rewritten or truncated.  The file lock must be held.
+func (pm *PersistedHistoryManager) keepCorrupt(file *historyFile) error {
+	if len(file.corrupt) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(pm.filename+".corrupt", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strings.Join(file.corrupt, "\n") + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// the lines are only kept once, even if the file is read again before it is rewritten
+	file.corrupt = nil
+	return nil
+}
+
+// writeHistoryFile atomically replaces the history file with the supplied entries, in the configured format.  The file
+// lock must be held.
+func (pm *PersistedHistoryManager) writeHistoryFile(entries []*HistoryEntry) error {
 	commands := []string{}
-	for iter.Next() {
-		commands = append(commands, base64.StdEncoding.EncodeToString([]byte(iter.Value()))+"\n")
+	if header := pm.codec.header(); header != "" {
+		commands = append(commands, header+"\n")
 	}
-	err = os.WriteFile(pm.filename, []byte(strings.Join(commands, "")), 0644)
+	for _, entry := range entries {
+		record, err := pm.codec.encode(entry)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, record+"\n")
+	}
+
+	return writeFileAtomic(pm.filename, []byte(strings.Join(commands, "")))
+}
+
+// writeFileAtomic replaces the file with the data, by writing and syncing a temporary file which is renamed over it
+func writeFileAtomic(filename string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// compact rewrites the history file without the records which have been dropped from the history, such as older
+// duplicates and those beyond the number to keep or the retention policy, archiving them if configured.  The file is
+// only rewritten if it would shrink.
+func (pm *PersistedHistoryManager) compact() error {
+	if pm.readOnly.Load() {
+		return nil
+	}
+
+	err := pm.flushChanges()
+	if err != nil {
+		return err
+	}
+
+	pm.fileLock.Lock()
+	defer pm.fileLock.Unlock()
+
+	file, err := pm.readHistoryFile()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// other processes may have appended to the file, so the records are compacted from the file rather than memory
+	compacted := NewBasicHistoryManager(pm.config.MaxKeep)
+	compacted.SetDedupMode(pm.config.DedupMode)
+	compacted.SetRetention(RetentionPolicy{MaxAge: pm.config.Retention.MaxAge})
+	for _, entry := range file.entries {
+		compacted.PushEntry(entry)
+	}
+	kept, err := pm.trimToSize(compacted.entries)
+	if err != nil {
+		return err
+	}
+
+	if file.matchesFormat && len(kept) == len(file.entries) {
+		return nil
+	}
+
+	// nothing is removed from the file unless it has been archived
+	err = pm.archiveDropped(file.entries, kept)
+	if err != nil {
+		return err
+	}
+	err = pm.keepCorrupt(file)
+	if err != nil {
+		return err
+	}
+	err = pm.writeHistoryFile(kept)
+	if err != nil {
+		return err
+	}
+	if pm.config.ShareHistory {
+		// the replaced file no longer has our offset
+		pm.updateFileOffset()
+	}
+
+	return nil
+}
+
+// RotateKey re-encrypts the history file with a new key.  An unencrypted history is encrypted from then on.  Other
+// processes sharing the file must be configured with the new key, or with it among their previous keys.
+func (pm *PersistedHistoryManager) RotateKey(key []byte) error {
+	return pm.rotate(&HistoryEncryption{Key: key})
+}
+
+// RotatePassphrase re-encrypts the history file with a key derived from a new passphrase, and a new salt
+func (pm *PersistedHistoryManager) RotatePassphrase(passphrase string) error {
+	return pm.rotate(&HistoryEncryption{Passphrase: passphrase})
+}
+
+func (pm *PersistedHistoryManager) rotate(encryption *HistoryEncryption) error {
+	if pm.readOnly.Load() {
+		return ErrHistoryReadOnly
+	}
+
+	err := pm.flushChanges()
+	if err != nil {
+		return err
+	}
+
+	pm.fileLock.Lock()
+	defer pm.fileLock.Unlock()
+
+	codec, err := newHistoryCodec(pm.config.Format, encryption, nil)
+	if err != nil {
+		return err
+	}
+
+	file, err := pm.readHistoryFile()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	usage, usageErr := pm.readUsage()
+	prevCodec := pm.codec
+	archiveCodecs := pm.archiveCodecs()
+	if file != nil {
+		err = pm.keepCorrupt(file)
+		if err != nil {
+			return err
+		}
+	}
+	pm.codec = codec
+	if file != nil {
+		err = pm.writeHistoryFile(file.entries)
+		if err != nil {
+			pm.codec = prevCodec
+			return err
+		}
+		pm.updateFileOffset()
+	}
+	if usageErr == nil {
+		err = pm.saveUsage(usage)
+		if err != nil {
+			return err
+		}
+	}
+	pm.config.Encryption = encryption
+
+	if pm.config.Retention.ArchiveDir != "" {
+		// archives encrypted with the previous key are unreadable once it has been replaced
+		return rewriteHistoryArchives(pm.config.Retention.ArchiveDir, archiveCodecs, codec, true, func(entries []*HistoryEntry) ([]*HistoryEntry, bool) {
+			return entries, false
+		})
+	}
 
 	return nil
 }
@@ -0,0 +1,343 @@
+package ns
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistedHistoryEntries(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pm := NewPersistedHistoryManager(10, filename)
+	pm.PushEntry(&HistoryEntry{
+		Command:    "deploy --env prod",
+		StartedAt:  started,
+		Duration:   time.Second,
+		ExitStatus: 2,
+		Error:      "failed",
+		WorkingDir: "/srv",
+		SessionID:  "abc",
+	})
+	pm.Push("status")
+	pm.Exit()
+
+	pm = NewPersistedHistoryManager(10, filename)
+	defer pm.Exit()
+	entries := pm.Entries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "deploy --env prod", entries[0].Command)
+	assert.True(t, started.Equal(entries[0].StartedAt))
+	assert.Equal(t, time.Second, entries[0].Duration)
+	assert.Equal(t, 2, entries[0].ExitStatus)
+	assert.Equal(t, "/srv", entries[0].WorkingDir)
+	assert.Equal(t, "abc", entries[0].SessionID)
+	assert.Equal(t, "status", entries[1].Command)
+
+	found := pm.SearchEntries("ENV")
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "abc", found[0].SessionID)
+}
+
+func TestPersistedHistoryCommandOnlyLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	enc := base64.StdEncoding.EncodeToString([]byte("ls -la"))
+	assert.NoError(t, os.WriteFile(filename, []byte(enc+"\n"), 0644))
+
+	pm := NewPersistedHistoryManager(10, filename)
+	defer pm.Exit()
+	assert.Equal(t, []string{"ls -la"}, pm.Search("ls"))
+}
+
+func TestPersistedHistoryMigration(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	legacy := []string{
+		base64.StdEncoding.EncodeToString([]byte("ls -la")),
+		"!!! not base64 !!!",
+		base64.StdEncoding.EncodeToString([]byte("make test")),
+	}
+	assert.NoError(t, os.WriteFile(filename, []byte(strings.Join(legacy, "\n")+"\n"), 0644))
+
+	errs := []error{}
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	pm.Push("go build")
+	pm.Exit()
+
+	assert.Equal(t, 1, len(errs))
+	var lineErr *HistoryLineError
+	assert.True(t, errors.As(errs[0], &lineErr))
+	assert.Equal(t, 2, lineErr.Line)
+
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(fBytes)), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, `{"format":"nilshell-history","version":2}`, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], `{"command":"ls -la"`))
+	assert.True(t, strings.HasPrefix(lines[3], `{"command":"go build"`))
+
+	// the corrupt line is kept aside rather than dropped by the rewrite
+	fBytes, err = os.ReadFile(filename + ".corrupt")
+	assert.NoError(t, err)
+	assert.Equal(t, "!!! not base64 !!!\n", string(fBytes))
+
+	pm = NewPersistedHistoryManager(10, filename)
+	defer pm.Exit()
+	assert.Equal(t, 3, len(pm.Entries()))
+}
+
+func TestPersistedHistoryCorruptLineReportedOnce(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	contents := `{"format":"nilshell-history","version":2}` + "\n" +
+		`{"command":"ls"}` + "\n" +
+		`{"command":` + "\n" +
+		`{"command":"pwd"}` + "\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(contents), 0644))
+
+	errs := []error{}
+	pm, err := newPersistedHistoryManager(PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, pm.compact())
+	assert.NoError(t, pm.compact())
+	pm.Delete("ls")
+	assert.NoError(t, pm.flushChanges())
+
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, []string{"pwd"}, commandsOf(pm.Entries()))
+}
+
+func TestPersistedHistoryBase64Format(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		Format:   HistoryFormatBase64,
+	})
+	pm.Push("ls")
+	pm.Exit()
+
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(fBytes), base64.StdEncoding.EncodeToString([]byte("ls"))+" "))
+}
+
+func TestPersistedHistoryNewerVersion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	contents := `{"format":"nilshell-history","version":99}` + "\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(contents), 0644))
+
+	var loadErr error
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		OnError: func(err error) {
+			loadErr = err
+		},
+	})
+	pm.Push("ls")
+	pm.Exit()
+
+	assert.ErrorIs(t, loadErr, ErrUnsupportedHistoryVersion)
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, string(fBytes))
+}
+
+func TestPersistedHistorySharing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:           10,
+		Filename:          filename,
+		ShareHistory:      true,
+		SharePollInterval: time.Hour,
+	}
+	a := NewPersistedHistoryManagerWithConfig(config)
+	defer a.Exit()
+	b := NewPersistedHistoryManagerWithConfig(config)
+	defer b.Exit()
+
+	b.Push("b first")
+	assert.NoError(t, b.flushChanges())
+	a.PushEntry(&HistoryEntry{Command: "a first", StartedAt: time.Now(), SessionID: "a"})
+	assert.NoError(t, a.flushChanges())
+	b.Push("b second")
+	assert.NoError(t, b.flushChanges())
+
+	assert.NoError(t, a.pollShared())
+	assert.Equal(t, []string{"b first"}, a.Search("b first"))
+	assert.Equal(t, []string{"b second"}, a.Search("b second"))
+
+	// the session's own commands come first
+	iter := a.GetIterator()
+	assert.Equal(t, "a first", iter.Backward())
+	assert.Equal(t, "b second", iter.Backward())
+	assert.Equal(t, "b first", iter.Backward())
+	assert.Equal(t, "b first", iter.Backward())
+
+	// commands are not imported twice
+	assert.NoError(t, b.pollShared())
+	assert.Equal(t, 3, len(b.Entries()))
+
+	// an imported command isn't the session's previous command, so running it next isn't skipped as a repeat
+	a.Push("b second")
+	assert.Equal(t, 2, len(a.SearchEntries("b second")))
+}
+
+func TestPersistedHistoryCompaction(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	config := PersistedHistoryConfig{
+		MaxKeep:   3,
+		Filename:  filename,
+		DedupMode: DedupErase,
+	}
+
+	pm := NewPersistedHistoryManagerWithConfig(config)
+	for _, cmd := range []string{"a", "b", "a", "c", "d"} {
+		pm.Push(cmd)
+	}
+	assert.NoError(t, pm.flushChanges())
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(strings.Split(strings.TrimSpace(string(fBytes)), "\n")))
+
+	assert.NoError(t, pm.compact())
+	pm.Exit()
+
+	fBytes, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(fBytes)), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.True(t, strings.HasPrefix(lines[1], `{"command":"a"`))
+	assert.True(t, strings.HasPrefix(lines[3], `{"command":"d"`))
+}
+
+func TestPersistedHistoryWriteImmediate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:     10,
+		Filename:    filename,
+		WritePolicy: WriteImmediate,
+	})
+	defer pm.Exit()
+
+	pm.Push("ls")
+	fBytes, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(fBytes), `"command":"ls"`))
+}
+
+func TestPersistedHistoryWriteBatched(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:       10,
+		Filename:      filename,
+		WritePolicy:   WriteBatched,
+		FlushInterval: time.Hour,
+		BatchSize:     2,
+	})
+	defer pm.Exit()
+
+	pm.Push("ls")
+	pm.Push("pwd")
+	assert.Eventually(t, func() bool {
+		fBytes, _ := os.ReadFile(filename)
+		return strings.Contains(string(fBytes), `"command":"pwd"`)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPersistedHistoryTruncatedRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManager(10, filename)
+	pm.Push("ls")
+	pm.Exit()
+
+	// simulate a crash part way through writing a record
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"command":"pw`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	var errs []error
+	config := PersistedHistoryConfig{
+		MaxKeep:  10,
+		Filename: filename,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+	pm = NewPersistedHistoryManagerWithConfig(config)
+	assert.Equal(t, 1, len(errs))
+	assert.True(t, errors.Is(errs[0], ErrHistoryRecordTruncated))
+	pm.Push("pwd")
+	pm.Exit()
+
+	errs = nil
+	pm = NewPersistedHistoryManagerWithConfig(config)
+	defer pm.Exit()
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"ls", "pwd"}, commandsOf(pm.Entries()))
+}
+
+func TestPersistedHistoryWriteImmediateSharing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:           1000,
+		Filename:          filename,
+		WritePolicy:       WriteImmediate,
+		ShareHistory:      true,
+		SharePollInterval: time.Millisecond,
+	})
+
+	// immediate writes from this goroutine contend with share polling in the flush thread
+	for i := 0; i < 300; i++ {
+		pm.Push(fmt.Sprintf("cmd %d", i))
+	}
+	pm.Exit()
+
+	pm = NewPersistedHistoryManager(1000, filename)
+	defer pm.Exit()
+	assert.Equal(t, 300, len(pm.Entries()))
+}
+
+func TestPersistedHistoryReadOnlyWhileFlushing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history")
+	pm := NewPersistedHistoryManagerWithConfig(PersistedHistoryConfig{
+		MaxKeep:         10,
+		Filename:        filename,
+		FlushInterval:   time.Millisecond,
+		CompactInterval: time.Millisecond,
+		OnError:         func(err error) {},
+	})
+	defer pm.Exit()
+
+	// the flush thread finds the file has been replaced by a newer version while commands are pushed and edited
+	contents := `{"format":"nilshell-history","version":99}` + "\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(contents), 0644))
+	for i := 0; i < 100 && !pm.readOnly.Load(); i++ {
+		pm.Push(fmt.Sprintf("cmd %d", i))
+		pm.Delete(fmt.Sprintf("cmd %d", i))
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, pm.readOnly.Load())
+}
@@ -0,0 +1,170 @@
+package ns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+// pickerItem is a history entry which matches the picker query
+type pickerItem struct {
+	entry     *HistoryEntry
+	score     int
+	positions []int
+}
+
+// startPicker enters the history picker, using the current buffer as the initial query
+func (r *Reader) startPicker() {
+	r.pickerMode = true
+	r.pickerSaved = r.readBuffer
+	r.pickerEntries = nil
+	r.pickerQuery = ""
+	r.pickerItems = nil
+	r.clearPlaceholders()
+	r.editOffset = len(r.readBuffer)
+	r.requireFullRender = true
+
+	// most recent first, with only the most recent occurrence of each command
+	entries := historyEntries(r.config.HistoryManager)
+	seen := map[string]struct{}{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if _, ok := seen[entries[i].Command]; ok {
+			continue
+		}
+		seen[entries[i].Command] = struct{}{}
+		r.pickerEntries = append(r.pickerEntries, entries[i])
+	}
+}
+
+// updatePicker filters the picker entries by the query in the read buffer
+func (r *Reader) updatePicker() {
+	query := string(r.readBuffer)
+	if r.pickerItems != nil && query == r.pickerQuery {
+		return
+	}
+
+	r.pickerQuery = query
+	r.pickerIndex = 0
+	r.pickerOffset = 0
+	r.pickerItems = []*pickerItem{}
+	for _, entry := range r.pickerEntries {
+		score, positions, ok := FuzzyMatch(query, entry.Command)
+		if ok {
+			r.pickerItems = append(r.pickerItems, &pickerItem{entry: entry, score: score, positions: positions})
+		}
+	}
+
+	// best match first, the entries are already ordered from most recent to oldest
+	sort.SliceStable(r.pickerItems, func(i, j int) bool {
+		return r.pickerItems[i].score > r.pickerItems[j].score
+	})
+}
+
+// movePicker moves the picker selection, scrolling as necessary
+func (r *Reader) movePicker(direction int) {
+	r.pickerIndex += direction
+	if r.pickerIndex >= len(r.pickerItems) {
+		r.pickerIndex = len(r.pickerItems) - 1
+	}
+	if r.pickerIndex < 0 {
+		r.pickerIndex = 0
+	}
+
+	rows := r.config.HistoryPickerRows
+	if r.pickerIndex < r.pickerOffset {
+		r.pickerOffset = r.pickerIndex
+	} else if r.pickerIndex >= r.pickerOffset+rows {
+		r.pickerOffset = r.pickerIndex - rows + 1
+	}
+	r.requireFullRender = true
+}
+
+// selectedPickerEntry returns the selected entry, or nil
+func (r *Reader) selectedPickerEntry() *HistoryEntry {
+	if r.pickerIndex < 0 || r.pickerIndex >= len(r.pickerItems) {
+		return nil
+	}
+
+	return r.pickerItems[r.pickerIndex].entry
+}
+
+// exitPicker leaves the picker, placing the selected entry (if any) in the buffer
+func (r *Reader) exitPicker(accept bool) {
+	if entry := r.selectedPickerEntry(); accept && entry != nil {
+		r.readBuffer = []rune(entry.Command)
+	} else {
+		r.readBuffer = r.pickerSaved
+	}
+	r.editOffset = len(r.readBuffer)
+
+	r.pickerMode = false
+	r.pickerEdit = false
+	r.historyNav = nil
+	r.pickerSaved = nil
+	r.pickerEntries = nil
+	r.pickerItems = nil
+	r.pickerQuery = ""
+	r.panel = nil
+	r.requireFullRender = true
+}
+
+// pickerPanel renders the visible portion of the picker list
+func (r *Reader) pickerPanel() []string {
+	width := r.windowSize.Columns - 1
+	lines := []string{
+		fmt.Sprintf("%s%d/%d%s", termutils.STYLE_BOLD, len(r.pickerItems), len(r.pickerEntries), termutils.STYLE_RESET),
+	}
+
+	end := r.pickerOffset + r.config.HistoryPickerRows
+	if end > len(r.pickerItems) {
+		end = len(r.pickerItems)
+	}
+
+	for i := r.pickerOffset; i < end; i++ {
+		item := r.pickerItems[i]
+		prefix := "  "
+		if i == r.pickerIndex {
+			prefix = "> "
+		}
+		if !item.entry.StartedAt.IsZero() {
+			prefix += item.entry.StartedAt.Local().Format("2006-01-02 15:04") + "  "
+		}
+
+		// crop before highlighting, to avoid cutting through the escape sequences
+		command, _ := termutils.Crop(strings.ReplaceAll(item.entry.Command, "\n", " "), width-termutils.Measure(prefix))
+		line := prefix + highlightPositions(command, item.positions)
+		if i == r.pickerIndex {
+			line = termutils.STYLE_REVERSE + line + termutils.STYLE_RESET
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// highlightPositions emboldens the runes of text at the supplied offsets
+func highlightPositions(text string, positions []int) string {
+	if len(positions) == 0 {
+		return text
+	}
+
+	highlighted := map[int]struct{}{}
+	for _, pos := range positions {
+		highlighted[pos] = struct{}{}
+	}
+
+	var sb strings.Builder
+	for i, ch := range []rune(text) {
+		if _, ok := highlighted[i]; ok {
+			sb.WriteString(termutils.STYLE_BOLD)
+			sb.WriteRune(ch)
+			sb.WriteString(termutils.STYLE_NORMAL_INTENSITY)
+			continue
+		}
+		sb.WriteRune(ch)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,38 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryPicker(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	for _, cmd := range []string{"git checkout main", "ls", "git commit", "git checkout main", "go test"} {
+		hm.Push(cmd)
+	}
+
+	r := NewReader(ReaderConfig{HistoryManager: hm})
+	r.updateBuffer("draft")
+	r.startPicker()
+	r.readBuffer = []rune{}
+	r.updatePicker()
+	assert.Equal(t, 4, len(r.pickerItems))
+	assert.Equal(t, "go test", r.selectedPickerEntry().Command)
+
+	r.readBuffer = []rune("gco")
+	r.updatePicker()
+	assert.Equal(t, 2, len(r.pickerItems))
+	assert.Equal(t, "git commit", r.selectedPickerEntry().Command)
+
+	r.readBuffer = []rune("gi")
+	r.updatePicker()
+	r.movePicker(1)
+	r.exitPicker(true)
+	assert.False(t, r.pickerMode)
+	assert.Equal(t, "git commit", string(r.readBuffer))
+
+	r.startPicker()
+	r.exitPicker(false)
+	assert.Equal(t, "git commit", string(r.readBuffer))
+}
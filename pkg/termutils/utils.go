@@ -23,6 +23,9 @@ const (
 	TERM_CLEAR_END_OF_LINE   = "\x1B[0K"
 	STYLE_RESET              = "\x1b[0m"
 	STYLE_BOLD               = "\x1b[1m"
+	STYLE_NORMAL_INTENSITY   = "\x1b[22m"
+	STYLE_UNDERLINE          = "\x1b[4m"
+	STYLE_REVERSE            = "\x1b[7m"
 )
 
 var (
@@ -174,3 +177,31 @@ func ClearTerminalFromCursor() {
 func ClearLineFromCursor() {
 	os.Stdout.WriteString(TERM_CLEAR_END_OF_LINE)
 }
+
+// Box surrounds the supplied lines with a border, no wider than maxWidth columns.  Lines which do not fit are cropped.  The
+// bordered lines are returned, without line terminators.
+func Box(lines []string, maxWidth int) []string {
+	innerWidth := 0
+	for _, line := range lines {
+		measured := Measure(line)
+		if measured > innerWidth {
+			innerWidth = measured
+		}
+	}
+
+	if innerWidth > maxWidth-4 {
+		innerWidth = maxWidth - 4
+	}
+	if innerWidth < 1 {
+		innerWidth = 1
+	}
+
+	boxed := make([]string, 0, len(lines)+2)
+	boxed = append(boxed, "┌"+strings.Repeat("─", innerWidth+2)+"┐")
+	for _, line := range lines {
+		boxed = append(boxed, "│ "+PadRight(line, innerWidth, 0)+STYLE_RESET+" │")
+	}
+	boxed = append(boxed, "└"+strings.Repeat("─", innerWidth+2)+"┘")
+
+	return boxed
+}
@@ -82,3 +82,20 @@ func TestGetCursorPosition(t *testing.T) {
 	assert.Equal(t, 13, row)
 	assert.Equal(t, 11, col)
 }
+
+func TestBox(t *testing.T) {
+	boxed := Box([]string{"hello", "hi"}, 80)
+	assert.Equal(t, 4, len(boxed))
+	assert.Equal(t, "┌───────┐", boxed[0])
+	assert.True(t, strings.HasPrefix(boxed[2], "│ hi   "))
+	for _, line := range boxed {
+		assert.Equal(t, 9, Measure(line))
+	}
+}
+
+func TestBoxCropped(t *testing.T) {
+	boxed := Box([]string{"this line is far too long"}, 12)
+	assert.Equal(t, 3, len(boxed))
+	assert.Equal(t, 12, Measure(boxed[1]))
+	assert.True(t, strings.Contains(boxed[1], "..."))
+}
@@ -0,0 +1,37 @@
+package ns
+
+// stashedLine is a partially typed command set aside by push-line
+type stashedLine struct {
+	buffer []rune
+	offset int
+}
+
+// pushLine stashes the buffer and cursor position, and clears the buffer for another command.  The stashed line is
+// restored at the next prompt, stashed lines being restored in reverse order.
+func (r *Reader) pushLine() {
+	if len(r.readBuffer) == 0 {
+		return
+	}
+
+	r.stashedLines = append(r.stashedLines, &stashedLine{buffer: r.readBuffer, offset: r.editOffset})
+	r.readBuffer = []rune{}
+	r.editOffset = 0
+	r.clearPlaceholders()
+	r.historyNav = nil
+	r.requireFullRender = true
+}
+
+// popLine restores the most recently stashed line into the buffer, returning false if there is none
+func (r *Reader) popLine() bool {
+	if len(r.stashedLines) == 0 {
+		return false
+	}
+
+	line := r.stashedLines[len(r.stashedLines)-1]
+	r.stashedLines = r.stashedLines[:len(r.stashedLines)-1]
+	r.readBuffer = line.buffer
+	r.editOffset = line.offset
+	r.requireFullRender = true
+
+	return true
+}
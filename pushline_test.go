@@ -0,0 +1,27 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushLine(t *testing.T) {
+	r := NewReader(ReaderConfig{})
+	r.updateBuffer("kubectl get pods")
+	r.editOffset = 7
+	r.pushLine()
+	assert.Equal(t, "", string(r.readBuffer))
+	assert.Equal(t, 0, r.editOffset)
+
+	r.updateBuffer("git status")
+	r.pushLine()
+	r.readBuffer = []rune{}
+
+	assert.True(t, r.popLine())
+	assert.Equal(t, "git status", string(r.readBuffer))
+	assert.True(t, r.popLine())
+	assert.Equal(t, "kubectl get pods", string(r.readBuffer))
+	assert.Equal(t, 7, r.editOffset)
+	assert.False(t, r.popLine())
+}
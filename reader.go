@@ -1,6 +1,8 @@
 package ns
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -25,21 +27,49 @@ var (
 )
 
 type Reader struct {
-	initialized       bool
-	config            ReaderConfig
-	editOffset        int
-	prevEditOffset    int
-	lastSuggestion    string
-	logFile           *os.File
-	readBuffer        []rune
-	requireFullRender bool
-	searchMode        bool
-	signalChan        chan os.Signal
-	windowSize        *Size
-	renderPosition    Position
-	editPosition      Position
-	windowSizeLock    sync.Mutex
-	waitGroup         sync.WaitGroup
+	initialized         bool
+	config              ReaderConfig
+	corrections         []string
+	drafts              *draftWriter
+	draftOffer          *draft
+	draftChecked        bool
+	editOffset          int
+	prevEditOffset      int
+	lastSuggestion      string
+	logFile             *os.File
+	output              io.Writer
+	panel               []string
+	pickerMode          bool
+	pickerEdit          bool
+	runEdited           bool
+	pickerSaved         []rune
+	pickerEntries       []*HistoryEntry
+	pickerItems         []*pickerItem
+	pickerQuery         string
+	pickerIndex         int
+	pickerOffset        int
+	placeholders        []*placeholder
+	placeholderIndex    int
+	placeholderSelected bool
+	preview             *Suggestion
+	readBuffer          []rune
+	requireFullRender   bool
+	searchMode          bool
+	searchForward       bool
+	searchFailing       bool
+	searchIndex         int
+	searchQuery         string
+	searchResults       []string
+	sessionID           string
+	incognito           bool
+	signalChan          chan os.Signal
+	stashedLines        []*stashedLine
+	windowSize          *Size
+	renderPosition      Position
+	editPosition        Position
+	historyNav          *historyNavigator
+	windowSizeLock      sync.Mutex
+	waitGroup           sync.WaitGroup
 }
 
 type Size struct {
@@ -54,13 +84,42 @@ type Position struct {
 
 type CompletionFunc func(beforeCursor string, afterCursor string, full string) *Suggestions
 
+// HelpFunc returns contextual help for the command under the cursor, or an empty string if none is available
+type HelpFunc func(beforeCursor string, afterCursor string, full string) string
+
 type ReaderConfig struct {
 	CompletionFunction CompletionFunc
+	HelpFunction       HelpFunc
 	ProcessFunction    func(string) error
 	HistoryManager     HistoryManager
 	PromptFunction     func() string
-	Debug              bool
-	LogFile            string
+	Correction         *CorrectionConfig
+	Abbreviations      *Abbreviations
+	// HistoryFilter determines which commands are recorded in the history, and redacts secrets from them
+	HistoryFilter *HistoryFilter
+	// SessionID is recorded with each history entry, a random identifier is generated when unset
+	SessionID string
+	// HistoryPickerRows is the number of entries visible in the history picker (default 10)
+	HistoryPickerRows int
+	// HistoryExpansion enables bash-style history expansion of submitted commands, or leave nil to disable
+	HistoryExpansion *HistoryExpansionConfig
+	// FcCommand handles lines of the form "fc [first [last]]" like bash, opening the selected history commands in $EDITOR
+	// and running the saved result, rather than passing the line to the process function
+	FcCommand bool
+	// DraftFile is the base name of the files in which the command being typed is saved, so that it can be restored after
+	// a crash or disconnect.  Each session saves to the file named by its process id appended to DraftFile, and the most
+	// recent draft left by a session which has exited is offered for restoring at the first prompt.  Leave empty to
+	// disable.
+	// Nothing is saved while incognito, and the HistoryFilter applies to the draft as it does to the history, but the
+	// draft is written in plain text even when the history is encrypted.
+	DraftFile string
+	// DraftInterval is the longest a change to the command being typed goes unsaved to the draft file (default 1 second)
+	DraftInterval time.Duration
+	// OnError is called with the errors of commands handled by the reader itself, such as fc, which don't end the read
+	// loop.  Errors are logged when unset.
+	OnError func(error)
+	Debug   bool
+	LogFile string
 }
 
 func NewReader(config ReaderConfig) *Reader {
@@ -89,16 +148,59 @@ func NewReader(config ReaderConfig) *Reader {
 		}
 	}
 
-	return &Reader{
-		config:     config,
-		signalChan: make(chan os.Signal, 10),
-		readBuffer: []rune{},
+	if config.HistoryPickerRows <= 0 {
+		config.HistoryPickerRows = 10
+	}
+
+	if config.Correction != nil {
+		correction := *config.Correction
+		if correction.MaxCandidates <= 0 {
+			correction.MaxCandidates = 3
+		}
+		if correction.MaxCandidates > 9 {
+			correction.MaxCandidates = 9
+		}
+		config.Correction = &correction
+	}
+
+	if config.DraftInterval <= 0 {
+		config.DraftInterval = time.Second
+	}
+
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			slog.Error(err.Error())
+		}
+	}
+
+	sessionID := config.SessionID
+	if sessionID == "" {
+		idBytes := make([]byte, 8)
+		rand.Read(idBytes)
+		sessionID = hex.EncodeToString(idBytes)
+	}
+
+	r := &Reader{
+		sessionID:        sessionID,
+		config:           config,
+		output:           os.Stdout,
+		signalChan:       make(chan os.Signal, 10),
+		readBuffer:       []rune{},
+		placeholderIndex: -1,
 	}
+	if config.DraftFile != "" {
+		r.drafts = newDraftWriter(draftFilename(config.DraftFile, os.Getpid()), config.DraftInterval)
+	}
+
+	return r
 }
 
 // ReadLoop reads commands from the standard input and blocks until exit
 func (r *Reader) ReadLoop() error {
-	defer r.config.HistoryManager.Exit()
+	defer func() {
+		// the history manager may have been replaced while reading
+		r.config.HistoryManager.Exit()
+	}()
 	if r.config.LogFile != "" {
 		var err error
 		r.logFile, err = os.OpenFile(r.config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
@@ -113,40 +215,154 @@ func (r *Reader) ReadLoop() error {
 	defer r.waitGroup.Wait()
 
 	for {
-		value, err := r.readLine()
+		rawValue, err := r.readLine()
 		switch err {
 		case ErrEof:
+			r.clearDraft()
 			r.signalChan <- syscall.SIGHUP
 			return nil
 		case ErrInterrupt:
+			r.clearDraft()
 			continue
 		}
 
 		if err != nil {
+			// the terminal may have gone away, save what was typed so far
+			if r.drafts != nil {
+				r.drafts.flush()
+			}
 			return err
 		}
+		r.clearDraft()
 
-		// do some processing on value if anything to process
-		if len(value) == 0 {
-			continue
+		if r.runEdited {
+			r.runEdited = false
+			err = r.runLines(rawValue)
+		} else if args, ok := parseFcCommand(rawValue); ok && r.config.FcCommand {
+			err = r.runFc(args)
+		} else {
+			err = r.runCommand(rawValue)
 		}
-
-		err = r.config.ProcessFunction(value)
 		if err == ErrEof {
 			r.signalChan <- syscall.SIGHUP
 			break
 		}
-
 		if err != nil {
 			return err
 		}
-		termutils.RequestCursorPos()
-		r.config.HistoryManager.Push(value)
 	}
 
 	return nil
 }
 
+// runCommand passes the command to the process function and records it in the history.  ErrEof is returned if the
+// process function requested the read loop to end.
+func (r *Reader) runCommand(rawValue string) error {
+	// do some processing on value if anything to process
+	value := strings.Trim(rawValue, " \t\r\n")
+	if len(value) == 0 {
+		return nil
+	}
+
+	entry := &HistoryEntry{
+		Command:   value,
+		StartedAt: time.Now(),
+		SessionID: r.sessionID,
+	}
+	entry.WorkingDir, _ = os.Getwd()
+
+	err := r.config.ProcessFunction(value)
+	entry.Duration = time.Since(entry.StartedAt)
+	if err == ErrEof {
+		return err
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		entry.ExitStatus = exitErr.Status
+		entry.Error = exitErr.Error()
+		err = nil
+	}
+
+	if err != nil {
+		return err
+	}
+	termutils.RequestCursorPos()
+	r.pushHistory(rawValue, entry)
+
+	return nil
+}
+
+// SessionID returns the identifier recorded with each history entry from this reader
+func (r *Reader) SessionID() string {
+	return r.sessionID
+}
+
+// HistoryManager returns the active history manager
+func (r *Reader) HistoryManager() HistoryManager {
+	return r.config.HistoryManager
+}
+
+// SetHistoryManager replaces the history manager, such as when switching between modes of the application which keep
+// separate histories.  The previous manager isn't exited, the manager active when the read loop ends is.
+func (r *Reader) SetHistoryManager(hm HistoryManager) {
+	if hm == nil {
+		hm = NewBasicHistoryManager(100)
+	}
+	r.config.HistoryManager = hm
+	r.historyNav = nil
+}
+
+// SetHistoryNamespace switches the namespace in which commands are recorded and navigated.  A history manager which isn't
+// a NamespacedHistory is wrapped in one, without falling back to other namespaces for search.  False is returned if the
+// history manager doesn't record entries, which namespaces require.
+func (r *Reader) SetHistoryNamespace(namespace string) bool {
+	switch hm := r.config.HistoryManager.(type) {
+	case *NamespacedHistory:
+		hm.SetNamespace(namespace)
+	case EntryHistoryManager:
+		r.SetHistoryManager(NewNamespacedHistory(hm, namespace, false))
+	default:
+		return false
+	}
+
+	r.historyNav = nil
+	return true
+}
+
+// SetIncognito pauses (or resumes) the recording of commands in the history
+func (r *Reader) SetIncognito(incognito bool) {
+	r.incognito = incognito
+}
+
+// Incognito returns true if the recording of commands in the history is paused
+func (r *Reader) Incognito() bool {
+	return r.incognito
+}
+
+// pushHistory records the entry in the history manager, along with its details if the manager supports them.  The raw
+// value is the line as it was entered, to which the history filter is applied.
+func (r *Reader) pushHistory(rawValue string, entry *HistoryEntry) {
+	if r.incognito {
+		return
+	}
+
+	if r.config.HistoryFilter != nil {
+		command, ok := r.config.HistoryFilter.Apply(rawValue)
+		if !ok {
+			return
+		}
+		entry.Command = command
+	}
+
+	if hm, ok := r.config.HistoryManager.(EntryHistoryManager); ok {
+		hm.PushEntry(entry)
+		return
+	}
+
+	r.config.HistoryManager.Push(entry.Command)
+}
+
 func (r *Reader) GetWindowSize() *Size {
 	r.windowSizeLock.Lock()
 	defer r.windowSizeLock.Unlock()
@@ -198,6 +414,8 @@ func (r *Reader) readLine() (string, error) {
 	r.editOffset = 0
 	r.prevEditOffset = 0
 	isNewLine := true
+	// a line stashed by push-line reappears once the command typed in its place has been run
+	r.popLine()
 
 	stdioFd := int(os.Stdin.Fd())
 	preState, err := term.MakeRaw(stdioFd)
@@ -207,7 +425,12 @@ func (r *Reader) readLine() (string, error) {
 	var suggestions *Suggestions
 
 	defer func() {
+		if r.pickerMode {
+			r.exitPicker(false)
+		}
 		r.readBuffer = []rune{}
+		r.clearPlaceholders()
+		r.historyNav = nil
 		rErr := recover()
 
 		err = term.Restore(stdioFd, preState)
@@ -216,18 +439,18 @@ func (r *Reader) readLine() (string, error) {
 		}
 
 		if rErr != nil {
-			fmt.Printf("caught panic in called function\n%v\n", err)
+			fmt.Fprintf(r.output, "caught panic in called function\n%v\n", err)
 			if r.config.Debug {
-				fmt.Println(string(debug.Stack()))
+				fmt.Fprintln(r.output, string(debug.Stack()))
 			}
 		}
 
-		fmt.Printf("\n")
+		fmt.Fprintf(r.output, "\n")
 		r.renderPosition.Row += renderLines + 1
 		if r.renderPosition.Row > r.windowSize.Rows {
 			r.renderPosition.Row = r.windowSize.Rows
 		}
-		r.searchMode = false
+		r.resetSearch()
 	}()
 
 	if !r.initialized {
@@ -235,24 +458,34 @@ func (r *Reader) readLine() (string, error) {
 		termutils.RequestCursorPos()
 		r.requireFullRender = true
 	}
+	r.offerDraft()
 
 	stdinBuf := make([]byte, 100)
 
-	var historyIter HistoryIterator
 	var suggLines int
+	var panelLines int
 	var renderLength int
 	for {
+		if r.searchMode {
+			r.updateSearch()
+		}
+		if r.pickerMode {
+			r.updatePicker()
+			r.panel = r.pickerPanel()
+			r.requireFullRender = true
+		}
+		r.saveDraft()
 		prompt := r.getCurrentPrompt()
 		if r.initialized {
 			termutils.HideCursor()
-			renderLength, renderLines, suggLines = r.render(prompt, isNewLine, suggestions)
+			renderLength, renderLines, suggLines, panelLines = r.render(prompt, isNewLine, suggestions)
 			if suggLines > 0 {
 				r.renderPosition.Row += suggLines
 				if r.renderPosition.Row > r.windowSize.Rows {
 					r.renderPosition.Row = r.windowSize.Rows
 				}
-			} else if r.renderPosition.Row+renderLines > r.windowSize.Rows {
-				r.renderPosition.Row = r.windowSize.Rows - renderLines
+			} else if r.renderPosition.Row+renderLines+panelLines > r.windowSize.Rows {
+				r.renderPosition.Row = r.windowSize.Rows - renderLines - panelLines
 			}
 			isNewLine = false
 			suggestions = nil
@@ -269,6 +502,76 @@ func (r *Reader) readLine() (string, error) {
 		}
 		inputData := string(stdinBuf[:nBytesRead])
 
+		if r.corrections != nil {
+			corrections := r.corrections
+			r.corrections = nil
+			if len(inputData) == 1 && inputData[0] >= '1' && int(inputData[0]-'1') < len(corrections) {
+				r.replaceCommand(corrections[inputData[0]-'1'])
+				r.panel = nil
+				r.renderFinal(prompt)
+				return string(r.readBuffer), nil
+			}
+			if inputData == KEY_ENTER {
+				// run the command as typed
+				r.MoveCursorToRenderEnd(renderLength)
+				return string(r.readBuffer), nil
+			}
+		}
+
+		if r.draftOffer != nil && r.answerDraft(inputData) {
+			continue
+		}
+
+		if r.pickerMode {
+			switch inputData {
+			case KEY_UP_ARROW:
+				r.movePicker(-1)
+				continue
+			case KEY_DOWN_ARROW:
+				r.movePicker(1)
+				continue
+			case KEY_ENTER, KEY_TAB:
+				if !r.pickerEdit {
+					r.exitPicker(true)
+					continue
+				}
+				text, err := r.editPickerEntry()
+				if err != nil {
+					// leave the prompt line before the error is reported
+					fmt.Fprint(r.output, "\r\n")
+					r.config.OnError(err)
+					return "", ErrInterrupt
+				}
+				if text != "" {
+					// the lines are shown as they are run, like those of fc
+					r.readBuffer = []rune{}
+					r.editOffset = 0
+					r.clearPlaceholders()
+					r.renderFinal(r.getCurrentPrompt())
+					r.runEdited = true
+					return text, nil
+				}
+				continue
+			case KEY_ESCAPE:
+				r.exitPicker(false)
+				continue
+			case KEY_SHIFT_DEL:
+				r.deletePickerEntry()
+				continue
+			case KEY_CTRL_R, KEY_CTRL_S, KEY_ALT_R, KEY_ALT_E, KEY_ESC_Q, KEY_CTRL_T, KEY_F1, KEY_F1_ALT, KEY_ALT_H, KEY_SHIFT_TAB:
+				continue
+			}
+		}
+
+		if r.panel != nil && !r.pickerMode {
+			// any keystroke dismisses the panel, escape does nothing further
+			r.panel = nil
+			r.requireFullRender = true
+			if inputData == KEY_ESCAPE {
+				continue
+			}
+		}
+
 		switch inputData {
 		case KEY_CTRL_C:
 			r.MoveCursorToRenderEnd(renderLength)
@@ -279,20 +582,56 @@ func (r *Reader) readLine() (string, error) {
 		case KEY_ENTER:
 			r.MoveCursorToRenderEnd(renderLength)
 			if r.searchMode {
-				r.requireFullRender = true
-				r.searchMode = false
-				return r.lastSuggestion, nil
+				value := r.lastSuggestion
+				r.resetSearch()
+				return value, nil
+			}
+			if r.expandAbbreviation(true) {
+				r.renderFinal(prompt)
+			}
+			if r.expandHistory(prompt, true) {
+				continue
 			}
-			return strings.Trim(string(r.readBuffer), " \t\r\n"), nil
+			value := string(r.readBuffer)
+			if r.offerCorrections(value) {
+				continue
+			}
+			return value, nil
 		case KEY_CTRL_R:
 			if r.searchMode {
+				r.stepSearch(1)
 				continue
 			}
 			r.editOffset = 0
 			r.readBuffer = []rune{}
+			r.clearPlaceholders()
 			r.requireFullRender = true
 			r.searchMode = true
+		case KEY_CTRL_S:
+			if r.searchMode {
+				r.stepSearch(-1)
+			}
+		case KEY_SHIFT_DEL:
+			if r.searchMode {
+				r.deleteSearchResult()
+			}
+		case KEY_ALT_R:
+			if !r.searchMode {
+				r.startPicker()
+			}
+		case KEY_ALT_E:
+			if !r.searchMode {
+				r.startEditPicker()
+			}
+		case KEY_ESC_Q:
+			if !r.searchMode {
+				r.pushLine()
+			}
 		case KEY_LEFT_ARROW:
+			if r.searchMode {
+				r.exitSearch(false)
+				continue
+			}
 			if r.editOffset > 0 {
 				r.editOffset--
 			}
@@ -301,33 +640,23 @@ func (r *Reader) readLine() (string, error) {
 				continue
 			}
 
-			if historyIter == nil {
-				historyIter = r.config.HistoryManager.GetIterator()
-				r.readBuffer = []rune(historyIter.Backward())
-			} else {
-				r.readBuffer = []rune(historyIter.Backward())
+			if r.historyNav == nil || (r.historyNav.index < 0 && !r.historyNav.isOriginal(r.readBuffer)) {
+				// the text before the cursor limits navigation to commands which begin with it
+				r.historyNav = newHistoryNavigator(r.config.HistoryManager, r.readBuffer, string(r.readBuffer[:r.editOffset]))
 			}
-			r.editOffset = termutils.Measure(string(r.readBuffer))
-			r.requireFullRender = true
+			r.navigateHistory(1)
 		case KEY_DOWN_ARROW:
-			if r.searchMode {
+			if r.searchMode || r.historyNav == nil {
 				continue
 			}
 
-			if historyIter == nil {
-				historyIter = r.config.HistoryManager.GetIterator()
-				r.readBuffer = []rune(historyIter.Backward())
-			} else {
-				r.readBuffer = []rune(historyIter.Forward())
-			}
-			r.editOffset = termutils.Measure(string(r.readBuffer))
-			r.requireFullRender = true
+			r.navigateHistory(-1)
 		case KEY_ESCAPE:
 			if len(r.readBuffer) > 0 || r.searchMode {
 				r.editOffset = 0
 				r.readBuffer = []rune{}
-				r.requireFullRender = true
-				r.searchMode = false
+				r.clearPlaceholders()
+				r.resetSearch()
 			}
 		case KEY_TAB:
 			if r.searchMode {
@@ -335,8 +664,12 @@ func (r *Reader) readLine() (string, error) {
 					r.readBuffer = []rune(r.lastSuggestion)
 					r.editOffset = termutils.Measure(r.lastSuggestion)
 				}
-				r.requireFullRender = true
-				r.searchMode = false
+				r.resetSearch()
+				continue
+			}
+
+			if r.placeholders != nil {
+				r.nextPlaceholder(1)
 				continue
 			}
 
@@ -350,16 +683,26 @@ func (r *Reader) readLine() (string, error) {
 			}
 
 			if len(suggestions.Items) != 1 {
+				r.preview = suggestions.Find(r.currentWord())
 				continue
 			}
 
 			r.completeText([]rune(suggestions.Items[0].Value))
+			r.preview = suggestions.Items[0]
 			suggestions = nil
+		case KEY_SHIFT_TAB:
+			if r.placeholders != nil {
+				r.nextPlaceholder(-1)
+			}
 		case KEY_END:
 			r.editOffset = len(r.readBuffer)
 		case KEY_HOME:
 			r.editOffset = 0
 		case KEY_RIGHT_ARROW:
+			if r.searchMode {
+				r.exitSearch(true)
+				continue
+			}
 			if r.editOffset < len(r.readBuffer) {
 				r.editOffset++
 			}
@@ -368,6 +711,17 @@ func (r *Reader) readLine() (string, error) {
 			termutils.SetCursorPos(1, 1)
 			r.renderPosition.Row = 1
 			r.requireFullRender = true
+		case KEY_F1, KEY_F1_ALT, KEY_ALT_H:
+			if r.searchMode || r.config.HelpFunction == nil {
+				continue
+			}
+
+			help := r.config.HelpFunction(string(r.readBuffer[:r.editOffset]), string(r.readBuffer[r.editOffset:]), string(r.readBuffer))
+			help = strings.TrimRight(help, "\r\n")
+			if len(help) > 0 {
+				r.panel = termutils.Box(strings.Split(strings.ReplaceAll(help, "\r", ""), "\n"), r.windowSize.Columns)
+				r.requireFullRender = true
+			}
 		case KEY_CTRL_T:
 			if err := r.openInEditor(); err != nil {
 				fmt.Fprintf(os.Stderr, "\r\n%s\n", err)
@@ -381,11 +735,90 @@ func (r *Reader) readLine() (string, error) {
 				continue
 			}
 
+			if inputData == " " && !r.searchMode && !r.pickerMode && r.expandAbbreviation(false) && r.placeholders != nil {
+				// the expansion contains placeholders, which the cursor is now positioned at
+				continue
+			}
+			if inputData == " " && !r.searchMode && !r.pickerMode && r.config.HistoryExpansion != nil && r.config.HistoryExpansion.ExpandOnSpace {
+				r.expandHistory(prompt, false)
+			}
+
 			r.updateBuffer(inputData)
 		}
 	}
 }
 
+// offerCorrections displays the closest known commands if the command in value is unknown, returning true if any were
+// offered
+func (r *Reader) offerCorrections(value string) bool {
+	if r.config.Correction == nil {
+		return false
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return false
+	}
+	if _, ok := parseFcCommand(value); ok && r.config.FcCommand {
+		// the fc builtin is handled by the reader, so it isn't an unknown command
+		return false
+	}
+
+	var known []string
+	if r.config.Correction.KnownCommands != nil {
+		known = r.config.Correction.KnownCommands()
+	} else if suggestions := r.config.CompletionFunction("", "", ""); suggestions != nil {
+		for _, item := range suggestions.Items {
+			known = append(known, item.Value)
+		}
+	}
+
+	corrections := closestCommands(fields[0], known, r.config.Correction.MaxCandidates, r.config.Correction.MaxDistance)
+	if len(corrections) == 0 {
+		return false
+	}
+
+	lines := []string{fmt.Sprintf("%sUnknown command \"%s\", did you mean:%s", termutils.STYLE_BOLD, fields[0], termutils.STYLE_RESET)}
+	for i, correction := range corrections {
+		lines = append(lines, fmt.Sprintf("  %d) %s", i+1, correction))
+	}
+	lines = append(lines, fmt.Sprintf("1-%d to accept, <Enter> to run as typed, any other key to edit", len(corrections)))
+
+	r.corrections = corrections
+	r.panel = termutils.Box(lines, r.windowSize.Columns)
+	r.requireFullRender = true
+	return true
+}
+
+// replaceCommand replaces the first word of the buffer with command
+func (r *Reader) replaceCommand(command string) {
+	start := 0
+	for start < len(r.readBuffer) && (r.readBuffer[start] == ' ' || r.readBuffer[start] == '\t') {
+		start++
+	}
+	end := start
+	for end < len(r.readBuffer) && r.readBuffer[end] != ' ' && r.readBuffer[end] != '\t' {
+		end++
+	}
+
+	b := []rune{}
+	b = append(b, r.readBuffer[:start]...)
+	b = append(b, []rune(command)...)
+	b = append(b, r.readBuffer[end:]...)
+	r.readBuffer = b
+	r.editOffset = len(r.readBuffer)
+}
+
+// currentWord returns the portion of the word under the cursor which precedes the cursor
+func (r *Reader) currentWord() string {
+	i := r.editOffset
+	for i > 0 && r.readBuffer[i-1] != ' ' {
+		i--
+	}
+
+	return string(r.readBuffer[i:r.editOffset])
+}
+
 // completeText performs an autocomplete operation
 func (lr *Reader) completeText(input []rune) {
 	// hunt back to the previous either space, or beginning of the text from the current cursor position
@@ -403,6 +836,11 @@ func (lr *Reader) completeText(input []rune) {
 				return
 			}
 
+			if IsSnippet(inputStr) {
+				lr.insertSnippet(j, lr.editOffset, inputStr)
+				return
+			}
+
 			runePrefix := []rune(strPrefix)
 			b := []rune{}
 			if lr.editOffset > 0 {
@@ -423,13 +861,23 @@ func (lr *Reader) completeText(input []rune) {
 func (r *Reader) updateBuffer(data string) {
 	cutBegin := r.editOffset
 	cutEnd := r.editOffset
+	replaceSelection := false
+	if p := r.activePlaceholder(); p != nil && r.placeholderSelected && r.editOffset == p.end {
+		// the default value of the selected placeholder is replaced by whatever is typed
+		cutBegin = p.start
+		replaceSelection = true
+		r.placeholderSelected = false
+		r.requireFullRender = true
+	}
 
 	switch data {
 	case KEY_DEL:
-		cutEnd++
+		if !replaceSelection && cutEnd < len(r.readBuffer) {
+			cutEnd++
+		}
 		data = ""
 	case KEY_BACKSPACE:
-		if cutBegin > 0 {
+		if !replaceSelection && cutBegin > 0 {
 			cutBegin--
 		}
 		data = ""
@@ -446,12 +894,8 @@ func (r *Reader) updateBuffer(data string) {
 		newRunes = append(newRunes, r.readBuffer[cutEnd:]...)
 	}
 
-	if cutBegin != r.editOffset {
-		r.editOffset = cutBegin
-	} else {
-		r.editOffset += termutils.Measure(data)
-	}
-
+	r.shiftPlaceholders(cutBegin, cutEnd, len([]rune(data)))
+	r.editOffset = cutBegin + termutils.Measure(data)
 	r.readBuffer = newRunes
 }
 
@@ -499,43 +943,84 @@ func (r *Reader) makeSuggestionString(suggesions *Suggestions) (string, int) {
 	return strings.Join(suggString, ""), lines
 }
 
-// render renders the edit "line" and returns the number of screen rows used in the render
-func (r *Reader) render(prompt string, isNewLine bool, suggestions *Suggestions) (int, int, int) {
+// makePreviewString renders the preview of the selected suggestion, returning the rendered string and the number of lines
+// it occupies
+func (r *Reader) makePreviewString(suggestion *Suggestion) (string, int) {
+	preview := strings.TrimRight(suggestion.Preview(), "\r\n")
+	if len(preview) == 0 {
+		return "", 0
+	}
+
+	fg := termutils.CreateFgColor(150, 150, 150)
+	lines := 0
+	previewString := []string{"\r\n"}
+	header := fmt.Sprintf("%s%s%s", termutils.STYLE_BOLD, suggestion.Display, termutils.STYLE_RESET)
+	previewString = append(previewString, header, "\r\n", fg)
+	lines += (termutils.Measure(header) / r.windowSize.Columns) + 2
+
+	for _, line := range strings.Split(preview, "\n") {
+		line = strings.TrimRight(line, "\r")
+		previewString = append(previewString, "  ", line, "\r\n")
+		lines += ((termutils.Measure(line) + 2) / r.windowSize.Columns) + 1
+	}
+
+	previewString = append(previewString, termutils.STYLE_RESET)
+
+	return strings.Join(previewString, ""), lines
+}
+
+// render renders the edit "line" and returns the rendered length, the number of screen rows used in the render, the number
+// of rows used by suggestions above the line, and the number of rows used by the panel below it
+func (r *Reader) render(prompt string, isNewLine bool, suggestions *Suggestions) (int, int, int, int) {
 	length := 0
 	extraLines := 0
+	panelLines := 0
 	suffix := ""
 	readBufferString := string(r.readBuffer)
 	searchResult := ""
+	if len(r.placeholders) > 0 {
+		// placeholders are highlighted, which is only possible with a full render
+		r.requireFullRender = true
+	}
 	if r.searchMode {
 		suffix = "`"
-		searchResults := r.config.HistoryManager.Search(readBufferString)
-		if len(searchResults) == 0 {
-			searchResult = ": <no results found>"
-			r.lastSuggestion = ""
-		} else {
-			searchResult = fmt.Sprintf(": %s", searchResults[0])
-			r.lastSuggestion = searchResults[0]
-		}
+		searchResult = r.searchResultString()
+		// the prompt reflects the state of the search, so it must be rendered in full
+		r.requireFullRender = true
 	} else {
 		r.lastSuggestion = ""
 	}
 
 	hasSuggestions := suggestions != nil && len(suggestions.Items) > 0
+	hasPreview := r.preview != nil && r.preview.Preview != nil
 
-	if r.requireFullRender || hasSuggestions {
+	if r.requireFullRender || hasSuggestions || hasPreview {
 		r.MoveCursorToRenderStart()
 		if hasSuggestions {
 			suggString, suggLines := r.makeSuggestionString(suggestions)
 			extraLines += suggLines
-			fmt.Printf("%s", suggString)
+			fmt.Fprintf(r.output, "%s", suggString)
 		}
-		fmt.Printf("%s%s%s%s", prompt, readBufferString, suffix, searchResult)
+		if hasPreview {
+			previewString, previewLines := r.makePreviewString(r.preview)
+			extraLines += previewLines
+			fmt.Fprintf(r.output, "%s", previewString)
+		}
+		fmt.Fprintf(r.output, "%s%s%s%s", prompt, r.styledBuffer(), suffix, searchResult)
 		r.requireFullRender = false
 		length = termutils.Measure(prompt) + termutils.Measure(readBufferString) + termutils.Measure(suffix) + termutils.Measure(searchResult)
 		termutils.ClearTerminalFromCursor()
+		if len(r.panel) > 0 {
+			for _, line := range r.panel {
+				fmt.Fprintf(r.output, "\r\n%s", line)
+				panelLines += (termutils.Measure(line) / r.windowSize.Columns) + 1
+			}
+			// the panel must be redrawn (or cleared) on the next render
+			r.requireFullRender = true
+		}
 	} else if isNewLine {
 		// this is the first time rendering this line, we want to render the prompt
-		fmt.Printf("%s", prompt)
+		fmt.Fprintf(r.output, "%s", prompt)
 		length = termutils.Measure(prompt)
 	} else {
 		pos := r.prevEditOffset
@@ -544,7 +1029,7 @@ func (r *Reader) render(prompt string, isNewLine bool, suggestions *Suggestions)
 		}
 		r.SetEditCursorPosition(prompt, pos)
 		// the final space is the key to triggering scroll when the cursor reaches the end of the row
-		fmt.Printf("%s%s%s ", string(r.readBuffer[pos:]), suffix, searchResult)
+		fmt.Fprintf(r.output, "%s%s%s ", string(r.readBuffer[pos:]), suffix, searchResult)
 		if len(searchResult) > 0 {
 			termutils.ClearTerminalFromCursor()
 		} else {
@@ -553,15 +1038,30 @@ func (r *Reader) render(prompt string, isNewLine bool, suggestions *Suggestions)
 		length = termutils.Measure(prompt) + termutils.Measure(readBufferString) + termutils.Measure(suffix) + termutils.Measure(searchResult)
 	}
 	r.prevEditOffset = r.editOffset
+	r.preview = nil
 
-	return length, (length / r.windowSize.Columns), extraLines
+	return length, (length / r.windowSize.Columns), extraLines, panelLines
 }
 
-// openInEditor takes the contents of the LineReader buffer and stores it in a temp file, which is
-// then opened in the user's $EDITOR. After the editor is closed the contents of the file are put
-// back into the LineReader buffer.
-func (lr *Reader) openInEditor() (err error) {
-	// Create a temp file to store the buffer in.
+// openInEditor takes the contents of the LineReader buffer and opens it in the user's $EDITOR. After
+// the editor is closed the contents of the file are put back into the LineReader buffer.
+func (lr *Reader) openInEditor() error {
+	text, err := editText(string(lr.readBuffer))
+	if err != nil {
+		return err
+	}
+
+	lr.readBuffer = []rune(text)
+	lr.clearPlaceholders()
+	lr.editOffset = termutils.Measure(string(lr.readBuffer))
+	lr.requireFullRender = true
+	return nil
+}
+
+// editText stores the text in a temp file, which is then opened in the user's $EDITOR.  After the
+// editor is closed the contents of the file are returned, without a trailing newline.
+func editText(text string) (result string, err error) {
+	// Create a temp file to store the text in.
 	tmpFile, err := os.CreateTemp("", "ns-*")
 	if err != nil {
 		return
@@ -580,8 +1080,7 @@ func (lr *Reader) openInEditor() (err error) {
 	editor.Stdout = os.Stdout
 	editor.Stderr = os.Stderr
 
-	// Clear the buffer and return it.
-	tmpFile.WriteString(string(lr.readBuffer))
+	tmpFile.WriteString(text)
 	tmpFile.Close()
 
 	err = editor.Run() // Run the editor
@@ -589,7 +1088,7 @@ func (lr *Reader) openInEditor() (err error) {
 		return
 	}
 
-	// Open that temp file again, and put its contents back into the buffer.
+	// Open that temp file again, and read its contents back.
 	tmpFile, err = os.Open(tmpFile.Name())
 	if err != nil {
 		return
@@ -601,9 +1100,7 @@ func (lr *Reader) openInEditor() (err error) {
 		return
 	}
 
-	lr.readBuffer = []rune(strings.TrimSuffix(string(contents), "\n"))
-	lr.editOffset = termutils.Measure(string(lr.readBuffer))
-	lr.requireFullRender = true
+	result = strings.TrimSuffix(string(contents), "\n")
 	return
 }
 
@@ -624,11 +1121,15 @@ func (r *Reader) parseControlSequence(prompt string, input string) bool {
 }
 
 func (r *Reader) getCurrentPrompt() string {
+	if r.pickerMode {
+		return "(history) "
+	}
+
 	if !r.searchMode {
 		return r.config.PromptFunction()
 	}
 
-	return "(reverse-i-search) `"
+	return r.searchPrompt()
 }
 
 // resetsCursorPosition sets the cursor position to the beginning of the current rendering position.
@@ -676,6 +1177,32 @@ func (r *Reader) MoveCursorToRenderEnd(renderLength int) {
 	termutils.SetCursorPos(row, col)
 	r.editPosition.Row = row
 	r.editPosition.Column = col
+	// remove anything rendered beneath the line, such as a help panel
+	termutils.ClearTerminalFromCursor()
+}
+
+// navigateHistory moves to an older (positive direction) or newer command in the history, keeping the cursor after the
+// navigation prefix if there is one
+func (r *Reader) navigateHistory(direction int) {
+	buffer, ok := r.historyNav.move(r.readBuffer, direction)
+	if !ok {
+		return
+	}
+
+	r.readBuffer = buffer
+	r.editOffset = len(r.readBuffer)
+	if prefixLen := len([]rune(r.historyNav.prefix)); prefixLen > 0 && prefixLen <= len(r.readBuffer) {
+		r.editOffset = prefixLen
+	}
+	r.clearPlaceholders()
+	r.requireFullRender = true
+}
+
+// renderFinal renders the line in full, after a last minute change to the buffer, and moves the cursor to its end
+func (r *Reader) renderFinal(prompt string) {
+	r.requireFullRender = true
+	renderLength, _, _, _ := r.render(prompt, false, nil)
+	r.MoveCursorToRenderEnd(renderLength)
 }
 
 func (r *Reader) MoveCursorToRenderStart() {
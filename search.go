@@ -0,0 +1,126 @@
+package ns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+// updateSearch refreshes the incremental search results for the query in the read buffer.  A changed query restarts the
+// search from the most recent match.
+func (r *Reader) updateSearch() {
+	query := string(r.readBuffer)
+	if query != r.searchQuery || r.searchResults == nil {
+		r.searchQuery = query
+		if ranked, ok := r.config.HistoryManager.(rankContextSetter); ok {
+			workingDir, _ := os.Getwd()
+			ranked.SetRankContext(workingDir, r.sessionID)
+		}
+		r.searchResults = r.config.HistoryManager.Search(query)
+		r.searchIndex = 0
+		r.searchFailing = len(query) > 0 && len(r.searchResults) == 0
+	}
+
+	if r.searchIndex < len(r.searchResults) {
+		r.lastSuggestion = r.searchResults[r.searchIndex]
+	} else {
+		r.lastSuggestion = ""
+	}
+}
+
+// stepSearch moves to the next older match (or newer, when direction is negative), failing if there are no more
+func (r *Reader) stepSearch(direction int) {
+	r.searchForward = direction < 0
+	r.requireFullRender = true
+	next := r.searchIndex + direction
+	if next < 0 || next >= len(r.searchResults) {
+		r.searchFailing = true
+		return
+	}
+
+	r.searchIndex = next
+	r.searchFailing = false
+}
+
+// exitSearch leaves search mode, placing the current match in the buffer for editing, with the cursor at the start (or
+// end, when atEnd is set) of the matched text
+func (r *Reader) exitSearch(atEnd bool) {
+	if r.lastSuggestion != "" {
+		start, end := findFold(r.lastSuggestion, r.searchQuery)
+		r.readBuffer = []rune(r.lastSuggestion)
+		r.editOffset = len(r.readBuffer)
+		if start >= 0 {
+			r.editOffset = start
+			if atEnd {
+				r.editOffset = end
+			}
+		}
+	}
+	r.resetSearch()
+}
+
+// resetSearch leaves search mode
+func (r *Reader) resetSearch() {
+	r.requireFullRender = true
+	r.historyNav = nil
+	r.searchMode = false
+	r.searchForward = false
+	r.searchFailing = false
+	r.searchIndex = 0
+	r.searchQuery = ""
+	r.searchResults = nil
+}
+
+// searchPrompt returns the prompt which reflects the state of the search
+func (r *Reader) searchPrompt() string {
+	prompt := "reverse-i-search"
+	if r.searchForward {
+		prompt = "i-search"
+	}
+	if r.searchFailing {
+		prompt = "failing " + prompt
+	}
+
+	return fmt.Sprintf("(%s) `", prompt)
+}
+
+// searchResultString returns the current match, with the matched text highlighted
+func (r *Reader) searchResultString() string {
+	if r.lastSuggestion == "" {
+		return ": <no results found>"
+	}
+
+	start, end := findFold(r.lastSuggestion, r.searchQuery)
+	if start < 0 {
+		return fmt.Sprintf(": %s", r.lastSuggestion)
+	}
+
+	match := []rune(r.lastSuggestion)
+	return fmt.Sprintf(
+		": %s%s%s%s%s",
+		string(match[:start]),
+		termutils.STYLE_REVERSE,
+		string(match[start:end]),
+		termutils.STYLE_RESET,
+		string(match[end:]),
+	)
+}
+
+// findFold returns the rune offsets of the first case insensitive occurrence of substr in s, or -1, -1
+func findFold(s string, substr string) (int, int) {
+	if len(substr) == 0 {
+		return -1, -1
+	}
+
+	runes := []rune(s)
+	subRunes := []rune(substr)
+	for i := 0; i+len(subRunes) <= len(runes); i++ {
+		if strings.EqualFold(string(runes[i:i+len(subRunes)]), substr) {
+			return i, i + len(subRunes)
+		}
+	}
+
+	return -1, -1
+}
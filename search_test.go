@@ -0,0 +1,43 @@
+package ns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrementalSearch(t *testing.T) {
+	hm := NewBasicHistoryManager(10)
+	for _, cmd := range []string{"git status", "ls", "git log", "git push"} {
+		hm.Push(cmd)
+		time.Sleep(time.Millisecond)
+	}
+
+	r := NewReader(ReaderConfig{HistoryManager: hm})
+	r.searchMode = true
+	r.updateBuffer("GIT")
+	r.updateSearch()
+	assert.Equal(t, "git push", r.lastSuggestion)
+
+	r.stepSearch(1)
+	r.updateSearch()
+	assert.Equal(t, "git log", r.lastSuggestion)
+
+	r.stepSearch(1)
+	r.stepSearch(1)
+	r.updateSearch()
+	assert.Equal(t, "git status", r.lastSuggestion)
+	assert.True(t, r.searchFailing)
+	assert.Equal(t, "(failing reverse-i-search) `", r.searchPrompt())
+
+	r.stepSearch(-1)
+	r.updateSearch()
+	assert.Equal(t, "git log", r.lastSuggestion)
+	assert.Equal(t, "(i-search) `", r.searchPrompt())
+
+	r.exitSearch(true)
+	assert.False(t, r.searchMode)
+	assert.Equal(t, "git log", string(r.readBuffer))
+	assert.Equal(t, 3, r.editOffset)
+}
@@ -0,0 +1,609 @@
+package ns
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SegmentHistoryConfig configures a SegmentHistoryManager
+type SegmentHistoryConfig struct {
+	// MaxKeep is the number of entries to keep (default 100000).  Whole segments are removed, so up to one segment more
+	// may be retained.
+	MaxKeep int
+	// Dir is the directory holding the segment files
+	Dir string
+	// SegmentSize is the number of entries in each segment (default 10000)
+	SegmentSize int
+	// CachedSegments is the number of segment indexes kept in memory (default 4)
+	CachedSegments int
+	// MaxResults limits the number of results of a search (default 1000)
+	MaxResults int
+	// OnError is called with errors which occur while reading or writing the segments.  Errors are logged when unset.
+	OnError func(error)
+}
+
+// SegmentHistoryManager is a history manager for very large histories.  Entries are appended to segment files, and once
+// a segment is full it is sealed with an on-disk trigram index of its commands.  Only the entries of the active segment
+// and the indexes of recently searched segments are held in memory, the rest being read on demand.  The directory is
+// owned by a single process.
+type SegmentHistoryManager struct {
+	config SegmentHistoryConfig
+	lock   sync.Mutex
+	// sealed segments, from oldest to most recent
+	sealed []*historySegment
+	active *activeSegment
+	cache  *segmentIndexCache
+	total  int
+	prev   *HistoryEntry
+	// pushed counts the entries added since the manager was created, so that iterators can skip them
+	pushed int
+}
+
+// historySegment is a sealed segment
+type historySegment struct {
+	id    int
+	count int
+}
+
+// activeSegment is the segment to which entries are appended
+type activeSegment struct {
+	id      int
+	file    *os.File
+	size    int64
+	entries []*HistoryEntry
+	offsets []int64
+}
+
+// SegmentHistoryIterator iterates over the history from most recent to oldest, reading entries on demand
+type SegmentHistoryIterator struct {
+	manager *SegmentHistoryManager
+	total   int
+	pushed  int
+	index   int
+}
+
+func NewSegmentHistoryManager(config SegmentHistoryConfig) (*SegmentHistoryManager, error) {
+	if config.MaxKeep <= 0 {
+		config.MaxKeep = 100000
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = 10000
+	}
+	if config.CachedSegments <= 0 {
+		config.CachedSegments = 4
+	}
+	if config.MaxResults <= 0 {
+		config.MaxResults = 1000
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			slog.Error(err.Error())
+		}
+	}
+
+	m := &SegmentHistoryManager{
+		config: config,
+		cache:  newSegmentIndexCache(config.CachedSegments),
+	}
+	err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *SegmentHistoryManager) segmentFilename(id int) string {
+	return filepath.Join(m.config.Dir, fmt.Sprintf("%08d.seg", id))
+}
+
+func (m *SegmentHistoryManager) indexFilename(id int) string {
+	return filepath.Join(m.config.Dir, fmt.Sprintf("%08d.idx", id))
+}
+
+// load finds the segments in the directory, sealing any unsealed segment other than the most recent, which becomes the
+// active segment
+func (m *SegmentHistoryManager) load() error {
+	err := os.MkdirAll(m.config.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(m.config.Dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for _, match := range matches {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(match), ".seg"))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		isLast := i == len(ids)-1
+		count, err := readSegmentCount(m.indexFilename(id))
+		if err == nil {
+			m.sealed = append(m.sealed, &historySegment{id: id, count: count})
+			m.total += count
+			continue
+		}
+		if isLast {
+			return m.openActive(id)
+		}
+
+		// the segment was never sealed, or its index is damaged
+		entries, offsets, size, err := readSegmentFile(m.segmentFilename(id))
+		if err != nil {
+			return err
+		}
+		count, err = m.writeIndex(id, entries, append(offsets, size))
+		if err != nil {
+			return err
+		}
+		m.sealed = append(m.sealed, &historySegment{id: id, count: count})
+		m.total += count
+	}
+
+	nextID := 1
+	if len(ids) > 0 {
+		nextID = ids[len(ids)-1] + 1
+	}
+
+	return m.openActive(nextID)
+}
+
+// openActive opens the segment to which entries are appended, creating it if necessary.  A partially written trailing
+// record is discarded.
+func (m *SegmentHistoryManager) openActive(id int) error {
+	filename := m.segmentFilename(id)
+	entries, offsets, size, err := readSegmentFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if err == nil && size == 0 {
+		var n int
+		n, err = f.WriteString(encodeHistoryHeader(HistoryFormatJSONLines) + "\n")
+		size = int64(n)
+	}
+	if err == nil {
+		_, err = f.Seek(size, 0)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	m.active = &activeSegment{
+		id:      id,
+		file:    f,
+		size:    size,
+		entries: entries,
+		offsets: offsets,
+	}
+	m.total += len(entries)
+	if len(entries) > 0 {
+		m.prev = entries[len(entries)-1]
+	}
+
+	return nil
+}
+
+// readSegmentFile reads every complete record of a segment file, returning the entries, the offset of each, and the end
+// of the last complete line
+func readSegmentFile(filename string) ([]*HistoryEntry, []int64, int64, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	entries := []*HistoryEntry{}
+	offsets := []int64{}
+	offset := int64(0)
+	for offset < int64(len(data)) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			break
+		}
+		line := string(data[offset : offset+int64(end)])
+		start := offset
+		offset += int64(end) + 1
+
+		if start == 0 {
+			if _, hasHeader, _ := decodeHistoryHeader(line); hasHeader {
+				continue
+			}
+		}
+		entry, err := decodeHistoryRecord(line)
+		if err != nil || len(entry.Command) == 0 {
+			continue
+		}
+		entries = append(entries, entry)
+		offsets = append(offsets, start)
+	}
+
+	return entries, offsets, offset, nil
+}
+
+// writeIndex writes the index of a segment, returning the number of records it holds
+func (m *SegmentHistoryManager) writeIndex(id int, entries []*HistoryEntry, offsets []int64) (int, error) {
+	commands := make([]string, len(entries))
+	for i, entry := range entries {
+		commands[i] = entry.Command
+	}
+	idx := buildSegmentIndex(commands, offsets)
+	err := writeSegmentIndex(m.indexFilename(id), idx)
+	if err != nil {
+		return 0, err
+	}
+	m.cache.put(id, idx)
+
+	return idx.count(), nil
+}
+
+func (m *SegmentHistoryManager) Push(value string) {
+	m.PushEntry(&HistoryEntry{
+		Command:   value,
+		StartedAt: time.Now(),
+	})
+}
+
+// PushEntry appends an entry to the active segment, unless it repeats the previous command.  The segment is sealed once
+// it is full.
+func (m *SegmentHistoryManager) PushEntry(entry *HistoryEntry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.active == nil || (m.prev != nil && entry.Command == m.prev.Command && entry.Namespace == m.prev.Namespace) {
+		return
+	}
+	m.prev = entry
+
+	n, err := m.active.file.WriteString(encodeHistoryRecord(HistoryFormatJSONLines, entry) + "\n")
+	if err != nil {
+		// discard anything partially written, so that the offsets remain valid
+		m.active.file.Truncate(m.active.size)
+		m.active.file.Seek(m.active.size, 0)
+		m.config.OnError(err)
+		return
+	}
+	m.active.offsets = append(m.active.offsets, m.active.size)
+	m.active.entries = append(m.active.entries, entry)
+	m.active.size += int64(n)
+	m.total++
+	m.pushed++
+
+	if len(m.active.entries) >= m.config.SegmentSize {
+		err = m.seal()
+		if err != nil {
+			m.config.OnError(err)
+		}
+	}
+}
+
+// seal indexes the active segment, opens the next one, and removes the oldest segments beyond those needed to retain
+// the number of entries to keep
+func (m *SegmentHistoryManager) seal() error {
+	active := m.active
+	count, err := m.writeIndex(active.id, active.entries, append(active.offsets, active.size))
+	if err != nil {
+		return err
+	}
+	active.file.Close()
+	m.sealed = append(m.sealed, &historySegment{id: active.id, count: count})
+	m.active = nil
+
+	for len(m.sealed) > 0 && m.total-m.sealed[0].count >= m.config.MaxKeep {
+		oldest := m.sealed[0]
+		m.sealed = m.sealed[1:]
+		m.total -= oldest.count
+		m.cache.remove(oldest.id)
+		for _, filename := range []string{m.indexFilename(oldest.id), m.segmentFilename(oldest.id)} {
+			err = os.Remove(filename)
+			if err != nil && !os.IsNotExist(err) {
+				m.config.OnError(err)
+			}
+		}
+	}
+
+	return m.openActive(active.id + 1)
+}
+
+// segmentIndex returns the index of a sealed segment, loading it if it isn't cached
+func (m *SegmentHistoryManager) segmentIndex(segment *historySegment) (*segmentIndex, error) {
+	if idx, ok := m.cache.get(segment.id); ok {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(m.indexFilename(segment.id))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := decodeSegmentIndex(data)
+	if err == errCorruptSegmentIndex {
+		m.config.OnError(fmt.Errorf("%s: %w", m.indexFilename(segment.id), err))
+		return m.rebuildIndex(segment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.indexFilename(segment.id), err)
+	}
+	m.cache.put(segment.id, idx)
+
+	return idx, nil
+}
+
+// rebuildIndex replaces the damaged index of a sealed segment with one built from the segment file
+func (m *SegmentHistoryManager) rebuildIndex(segment *historySegment) (*segmentIndex, error) {
+	entries, offsets, size, err := readSegmentFile(m.segmentFilename(segment.id))
+	if err != nil {
+		return nil, err
+	}
+	count, err := m.writeIndex(segment.id, entries, append(offsets, size))
+	if err != nil {
+		return nil, err
+	}
+	m.total += count - segment.count
+	segment.count = count
+
+	idx, _ := m.cache.get(segment.id)
+	return idx, nil
+}
+
+// readRecord reads a single record of a sealed segment from the open segment file
+func readRecord(f *os.File, idx *segmentIndex, i int) (*HistoryEntry, error) {
+	buf := make([]byte, idx.offsets[i+1]-idx.offsets[i])
+	_, err := f.ReadAt(buf, idx.offsets[i])
+	if err != nil {
+		return nil, err
+	}
+
+	// the record ends at the first newline, as lines which couldn't be decoded have no offset of their own
+	line, _, _ := strings.Cut(string(buf), "\n")
+	return decodeHistoryRecord(line)
+}
+
+// activeEntries returns the entries of the active segment, which is only missing if it couldn't be opened after sealing
+func (m *SegmentHistoryManager) activeEntries() []*HistoryEntry {
+	if m.active == nil {
+		return nil
+	}
+
+	return m.active.entries
+}
+
+// entryAt returns the entry at the position, counting back from the most recent entry
+func (m *SegmentHistoryManager) entryAt(pos int) (*HistoryEntry, error) {
+	active := m.activeEntries()
+	if pos < len(active) {
+		return active[len(active)-1-pos], nil
+	}
+	pos -= len(active)
+
+	for i := len(m.sealed) - 1; i >= 0; i-- {
+		segment := m.sealed[i]
+		if pos >= segment.count {
+			pos -= segment.count
+			continue
+		}
+
+		idx, err := m.segmentIndex(segment)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(m.segmentFilename(segment.id))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return readRecord(f, idx, segment.count-1-pos)
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// search returns the entries containing the pattern (case insensitive), from most recent to oldest, reading only the
+// candidate records of each sealed segment
+func (m *SegmentHistoryManager) search(pattern string, unique bool) []*HistoryEntry {
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	pattern = strings.ToLower(pattern)
+	results := []*HistoryEntry{}
+	seen := map[string]struct{}{}
+	add := func(entry *HistoryEntry) bool {
+		if !strings.Contains(strings.ToLower(entry.Command), pattern) {
+			return true
+		}
+		if unique {
+			if _, ok := seen[entry.Command]; ok {
+				return true
+			}
+			seen[entry.Command] = struct{}{}
+		}
+		results = append(results, entry)
+
+		return len(results) < m.config.MaxResults
+	}
+
+	active := m.activeEntries()
+	for i := len(active) - 1; i >= 0; i-- {
+		if !add(active[i]) {
+			return results
+		}
+	}
+
+	for i := len(m.sealed) - 1; i >= 0; i-- {
+		done, err := m.searchSegment(m.sealed[i], pattern, add)
+		if err != nil {
+			m.config.OnError(err)
+		}
+		if done {
+			break
+		}
+	}
+
+	return results
+}
+
+// searchSegment offers each candidate record of a sealed segment, from most recent to oldest, returning true once no
+// more are wanted
+func (m *SegmentHistoryManager) searchSegment(segment *historySegment, pattern string, add func(*HistoryEntry) bool) (bool, error) {
+	idx, err := m.segmentIndex(segment)
+	if err != nil {
+		return false, err
+	}
+	candidates, ok := idx.candidates(pattern)
+	if !ok {
+		return false, nil
+	}
+	if candidates == nil {
+		candidates = make([]uint32, idx.count())
+		for i := range candidates {
+			candidates[i] = uint32(i)
+		}
+	}
+
+	f, err := os.Open(m.segmentFilename(segment.id))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		entry, err := readRecord(f, idx, int(candidates[i]))
+		if err != nil {
+			return false, err
+		}
+		if !add(entry) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Search returns the distinct commands containing the pattern (case insensitive), from most recent to oldest
+func (m *SegmentHistoryManager) Search(pattern string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := m.search(pattern, true)
+	if len(entries) == 0 {
+		return nil
+	}
+	commands := make([]string, len(entries))
+	for i, entry := range entries {
+		commands[i] = entry.Command
+	}
+
+	return commands
+}
+
+// SearchEntries returns the entries containing the pattern (case insensitive), from most recent to oldest
+func (m *SegmentHistoryManager) SearchEntries(pattern string) []*HistoryEntry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.search(pattern, false)
+}
+
+// Entries returns all entries, from oldest to most recent.  Every segment is read, so this should be avoided for large
+// histories.
+func (m *SegmentHistoryManager) Entries() []*HistoryEntry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := []*HistoryEntry{}
+	for _, segment := range m.sealed {
+		segmentEntries, _, _, err := readSegmentFile(m.segmentFilename(segment.id))
+		if err != nil {
+			m.config.OnError(err)
+			continue
+		}
+		entries = append(entries, segmentEntries...)
+	}
+
+	return append(entries, m.activeEntries()...)
+}
+
+func (m *SegmentHistoryManager) GetIterator() HistoryIterator {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return &SegmentHistoryIterator{
+		manager: m,
+		total:   m.total,
+		pushed:  m.pushed,
+		index:   -1,
+	}
+}
+
+func (m *SegmentHistoryManager) Exit() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.active != nil {
+		err := m.active.file.Close()
+		if err != nil {
+			m.config.OnError(err)
+		}
+		// nothing more is appended once exited
+		m.active = nil
+	}
+}
+
+func (shi *SegmentHistoryIterator) value() string {
+	shi.manager.lock.Lock()
+	defer shi.manager.lock.Unlock()
+
+	// entries pushed since the iterator was created are skipped
+	entry, err := shi.manager.entryAt(shi.index + shi.manager.pushed - shi.pushed)
+	if err != nil {
+		return ""
+	}
+
+	return entry.Command
+}
+
+func (shi *SegmentHistoryIterator) Forward() string {
+	if shi.total == 0 {
+		return ""
+	}
+
+	if shi.index > 0 {
+		shi.index--
+	}
+	if shi.index < 0 {
+		shi.index = 0
+	}
+	return shi.value()
+}
+
+func (shi *SegmentHistoryIterator) Backward() string {
+	if shi.total == 0 {
+		return ""
+	}
+
+	if shi.index < shi.total-1 {
+		shi.index++
+	}
+	return shi.value()
+}
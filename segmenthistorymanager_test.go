@@ -0,0 +1,155 @@
+package ns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentIndexEncoding(t *testing.T) {
+	idx := buildSegmentIndex([]string{"git status", "ls -la", "git commit"}, []int64{0, 10, 20, 30})
+	decoded, err := decodeSegmentIndex(idx.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, idx.offsets, decoded.offsets)
+
+	candidates, ok := decoded.candidates("git")
+	assert.True(t, ok)
+	assert.Equal(t, []uint32{0, 2}, candidates)
+	candidates, ok = decoded.candidates("comm")
+	assert.True(t, ok)
+	assert.Equal(t, []uint32{2}, candidates)
+	_, ok = decoded.candidates("xyz")
+	assert.False(t, ok)
+
+	_, err = decodeSegmentIndex([]byte("garbage"))
+	assert.Error(t, err)
+
+	// a damaged index is rejected rather than naming records which don't exist
+	damaged := &segmentIndex{offsets: []int64{0, 10, 20, 30}, trigrams: map[uint32][]uint32{1: {0, 3}}}
+	_, err = decodeSegmentIndex(damaged.encode())
+	assert.ErrorIs(t, err, errCorruptSegmentIndex)
+	damaged = &segmentIndex{offsets: []int64{0, 20, 10, 30}, trigrams: map[uint32][]uint32{}}
+	_, err = decodeSegmentIndex(damaged.encode())
+	assert.ErrorIs(t, err, errCorruptSegmentIndex)
+}
+
+func TestSegmentHistoryManager(t *testing.T) {
+	dir := t.TempDir()
+	config := SegmentHistoryConfig{MaxKeep: 100, Dir: dir, SegmentSize: 3, CachedSegments: 1}
+
+	m, err := NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		m.Push(fmt.Sprintf("cmd %d", i))
+	}
+	m.Push("cmd 9")
+	m.Push("Deploy prod")
+	m.Exit()
+
+	m, err = NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	defer m.Exit()
+
+	assert.Equal(t, []string{"cmd 9", "cmd 8", "cmd 7"}, m.Search("cmd")[:3])
+	assert.Equal(t, 10, len(m.Search("cmd")))
+	assert.Equal(t, []string{"cmd 2"}, m.Search("MD 2"))
+	assert.Equal(t, []string{"Deploy prod"}, m.Search("deploy"))
+	assert.Nil(t, m.Search("missing"))
+	assert.Equal(t, 11, len(m.Entries()))
+
+	iter := m.GetIterator()
+	assert.Equal(t, "Deploy prod", iter.Backward())
+	assert.Equal(t, "cmd 9", iter.Backward())
+	for i := 0; i < 20; i++ {
+		iter.Backward()
+	}
+	assert.Equal(t, "cmd 0", iter.Backward())
+	assert.Equal(t, "cmd 1", iter.Forward())
+}
+
+func TestSegmentHistoryDamagedIndex(t *testing.T) {
+	dir := t.TempDir()
+	config := SegmentHistoryConfig{MaxKeep: 100, Dir: dir, SegmentSize: 2}
+	m, err := NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	for _, cmd := range []string{"git status", "ls", "git log", "pwd"} {
+		m.Push(cmd)
+	}
+	m.Exit()
+
+	// the header is intact, but a posting names a record beyond the segment
+	damaged := &segmentIndex{offsets: []int64{0, 10, 20}, trigrams: map[uint32][]uint32{trigramKey("git", 0): {0, 7}}}
+	assert.NoError(t, writeSegmentIndex(filepath.Join(dir, "00000001.idx"), damaged))
+
+	errs := []error{}
+	config.OnError = func(err error) { errs = append(errs, err) }
+	m, err = NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	defer m.Exit()
+	assert.Equal(t, []string{"git log", "git status"}, m.Search("git"))
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorIs(t, errs[0], errCorruptSegmentIndex)
+}
+
+func TestSegmentHistoryRetention(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewSegmentHistoryManager(SegmentHistoryConfig{MaxKeep: 4, Dir: dir, SegmentSize: 2})
+	assert.NoError(t, err)
+	defer m.Exit()
+
+	for i := 0; i < 10; i++ {
+		m.Push(fmt.Sprintf("cmd %d", i))
+	}
+
+	commands := commandsOf(m.Entries())
+	assert.Equal(t, []string{"cmd 6", "cmd 7", "cmd 8", "cmd 9"}, commands)
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Equal(t, 3, len(segments))
+
+	// sealed segments are kept when MaxKeep is left unset
+	m, err = NewSegmentHistoryManager(SegmentHistoryConfig{Dir: t.TempDir(), SegmentSize: 2})
+	assert.NoError(t, err)
+	defer m.Exit()
+	for i := 0; i < 5; i++ {
+		m.Push(fmt.Sprintf("cmd %d", i))
+	}
+	assert.Equal(t, 5, len(m.Entries()))
+
+	// nothing is written once exited, and exiting again is harmless
+	errs := []error{}
+	m.config.OnError = func(err error) { errs = append(errs, err) }
+	m.Exit()
+	m.Push("cmd 5")
+	m.Exit()
+	assert.Empty(t, errs)
+}
+
+func TestSegmentHistoryRecovery(t *testing.T) {
+	dir := t.TempDir()
+	config := SegmentHistoryConfig{MaxKeep: 100, Dir: dir, SegmentSize: 10}
+
+	m, err := NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	m.Push("first")
+	m.Exit()
+
+	// a record cut short by a crash is discarded
+	f, err := os.OpenFile(filepath.Join(dir, "00000001.seg"), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"command":"sec`)
+	assert.NoError(t, err)
+	f.Close()
+
+	m, err = NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	m.Push("second")
+	m.Exit()
+
+	m, err = NewSegmentHistoryManager(config)
+	assert.NoError(t, err)
+	defer m.Exit()
+	assert.Equal(t, []string{"first", "second"}, commandsOf(m.Entries()))
+}
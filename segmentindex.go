@@ -0,0 +1,267 @@
+package ns
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	segmentIndexMagic   = "NSHI"
+	segmentIndexVersion = 1
+)
+
+var errCorruptSegmentIndex = errors.New("corrupt history segment index")
+
+// segmentIndex locates the records of a sealed segment, and the records containing each trigram of their lowercased
+// commands
+type segmentIndex struct {
+	// offsets holds the start of each record, followed by the end of the last record
+	offsets  []int64
+	trigrams map[uint32][]uint32
+}
+
+func trigramKey(s string, i int) uint32 {
+	return uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+}
+
+// buildSegmentIndex indexes the commands of a segment, whose records begin at the supplied offsets
+func buildSegmentIndex(commands []string, offsets []int64) *segmentIndex {
+	idx := &segmentIndex{
+		offsets:  offsets,
+		trigrams: map[uint32][]uint32{},
+	}
+	for i, command := range commands {
+		command = strings.ToLower(command)
+		seen := map[uint32]struct{}{}
+		for j := 0; j+3 <= len(command); j++ {
+			key := trigramKey(command, j)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			idx.trigrams[key] = append(idx.trigrams[key], uint32(i))
+		}
+	}
+
+	return idx
+}
+
+func (idx *segmentIndex) count() int {
+	return len(idx.offsets) - 1
+}
+
+// candidates returns the records which may contain the lowercased pattern, in ascending order.  Patterns shorter than a
+// trigram match every record, indicated by a nil result with ok set.
+func (idx *segmentIndex) candidates(pattern string) ([]uint32, bool) {
+	if len(pattern) < 3 {
+		return nil, true
+	}
+
+	var result []uint32
+	for j := 0; j+3 <= len(pattern); j++ {
+		postings, ok := idx.trigrams[trigramKey(pattern, j)]
+		if !ok {
+			return nil, false
+		}
+		if result == nil {
+			result = postings
+			continue
+		}
+		result = intersectPostings(result, postings)
+		if len(result) == 0 {
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
+func intersectPostings(a []uint32, b []uint32) []uint32 {
+	result := []uint32{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+// encode serializes the index as the magic and version, the record count, the record offsets, and the posting list of
+// each trigram, delta encoded
+func (idx *segmentIndex) encode() []byte {
+	data := []byte(segmentIndexMagic)
+	data = binary.LittleEndian.AppendUint32(data, segmentIndexVersion)
+	data = binary.LittleEndian.AppendUint32(data, uint32(idx.count()))
+	for _, offset := range idx.offsets {
+		data = binary.LittleEndian.AppendUint64(data, uint64(offset))
+	}
+
+	keys := make([]uint32, 0, len(idx.trigrams))
+	for key := range idx.trigrams {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(keys)))
+	for _, key := range keys {
+		postings := idx.trigrams[key]
+		data = binary.LittleEndian.AppendUint32(data, key)
+		data = binary.AppendUvarint(data, uint64(len(postings)))
+		prev := uint32(0)
+		for _, posting := range postings {
+			data = binary.AppendUvarint(data, uint64(posting-prev))
+			prev = posting
+		}
+	}
+
+	return data
+}
+
+func decodeSegmentIndex(data []byte) (*segmentIndex, error) {
+	if len(data) < 12 || string(data[:4]) != segmentIndexMagic || binary.LittleEndian.Uint32(data[4:]) != segmentIndexVersion {
+		return nil, errCorruptSegmentIndex
+	}
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+	data = data[12:]
+	if len(data) < (count+1)*8+4 {
+		return nil, errCorruptSegmentIndex
+	}
+
+	idx := &segmentIndex{
+		offsets:  make([]int64, count+1),
+		trigrams: map[uint32][]uint32{},
+	}
+	for i := range idx.offsets {
+		idx.offsets[i] = int64(binary.LittleEndian.Uint64(data[i*8:]))
+		// records are read from between consecutive offsets
+		if idx.offsets[i] < 0 || (i > 0 && idx.offsets[i] < idx.offsets[i-1]) {
+			return nil, errCorruptSegmentIndex
+		}
+	}
+	data = data[(count+1)*8:]
+
+	numKeys := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	for i := 0; i < numKeys; i++ {
+		if len(data) < 4 {
+			return nil, errCorruptSegmentIndex
+		}
+		key := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+
+		numPostings, n := binary.Uvarint(data)
+		if n <= 0 || numPostings > uint64(count) {
+			return nil, errCorruptSegmentIndex
+		}
+		data = data[n:]
+
+		postings := make([]uint32, numPostings)
+		prev := uint32(0)
+		for j := range postings {
+			delta, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errCorruptSegmentIndex
+			}
+			data = data[n:]
+			// postings index the offsets, so each must name one of the records
+			if delta >= uint64(count) || uint64(prev)+delta >= uint64(count) {
+				return nil, errCorruptSegmentIndex
+			}
+			prev += uint32(delta)
+			postings[j] = prev
+		}
+		idx.trigrams[key] = postings
+	}
+
+	return idx, nil
+}
+
+// readSegmentCount reads the number of records in a segment from the header of its index, without loading the index
+func readSegmentCount(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return 0, errCorruptSegmentIndex
+	}
+	if string(header[:4]) != segmentIndexMagic || binary.LittleEndian.Uint32(header[4:]) != segmentIndexVersion {
+		return 0, errCorruptSegmentIndex
+	}
+
+	return int(binary.LittleEndian.Uint32(header[8:])), nil
+}
+
+// writeSegmentIndex atomically writes the index file
+func writeSegmentIndex(filename string, idx *segmentIndex) error {
+	return writeFileAtomic(filename, idx.encode())
+}
+
+// segmentIndexCache keeps the most recently used segment indexes in memory
+type segmentIndexCache struct {
+	capacity int
+	order    *list.List
+	items    map[int]*list.Element
+}
+
+type segmentIndexCacheItem struct {
+	id  int
+	idx *segmentIndex
+}
+
+func newSegmentIndexCache(capacity int) *segmentIndexCache {
+	return &segmentIndexCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[int]*list.Element{},
+	}
+}
+
+func (c *segmentIndexCache) get(id int) (*segmentIndex, bool) {
+	elem, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*segmentIndexCacheItem).idx, true
+}
+
+func (c *segmentIndexCache) put(id int, idx *segmentIndex) {
+	if elem, ok := c.items[id]; ok {
+		elem.Value.(*segmentIndexCacheItem).idx = idx
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[id] = c.order.PushFront(&segmentIndexCacheItem{id: id, idx: idx})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*segmentIndexCacheItem).id)
+	}
+}
+
+func (c *segmentIndexCache) remove(id int) {
+	if elem, ok := c.items[id]; ok {
+		c.order.Remove(elem)
+		delete(c.items, id)
+	}
+}
@@ -0,0 +1,226 @@
+package ns
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+// placeholder is a tab stop within a snippet which has been inserted into the read buffer.  Offsets are rune offsets into
+// the read buffer, with end being exclusive.
+type placeholder struct {
+	index int
+	start int
+	end   int
+}
+
+// IsSnippet returns true if the supplied text contains snippet placeholders such as $1, ${2} or ${3:default}
+func IsSnippet(text string) bool {
+	_, placeholders := parseSnippet(text)
+	return len(placeholders) > 0
+}
+
+// parseSnippet parses snippet text containing tab stops ($1, ${1}), tab stops with default values (${1:staging}) and the
+// final cursor position ($0).  A dollar sign may be escaped with a backslash.  Returned are the text with the placeholder
+// syntax removed, and the placeholders in tab order (1, 2, ... followed by 0).
+func parseSnippet(snippet string) ([]rune, []*placeholder) {
+	src := []rune(snippet)
+	text := []rune{}
+	placeholders := []*placeholder{}
+
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\\' && i+1 < len(src) && src[i+1] == '$' {
+			text = append(text, '$')
+			i++
+			continue
+		}
+
+		if src[i] != '$' || i+1 >= len(src) {
+			text = append(text, src[i])
+			continue
+		}
+
+		if isDigit(src[i+1]) {
+			j := i + 1
+			index := 0
+			for j < len(src) && isDigit(src[j]) {
+				index = index*10 + int(src[j]-'0')
+				j++
+			}
+			placeholders = append(placeholders, &placeholder{index: index, start: len(text), end: len(text)})
+			i = j - 1
+			continue
+		}
+
+		if src[i+1] == '{' && i+2 < len(src) && isDigit(src[i+2]) {
+			j := i + 2
+			index := 0
+			for j < len(src) && isDigit(src[j]) {
+				index = index*10 + int(src[j]-'0')
+				j++
+			}
+
+			var defaultValue []rune
+			if j < len(src) && src[j] == ':' {
+				k := j + 1
+				for k < len(src) && src[k] != '}' {
+					k++
+				}
+				defaultValue = src[j+1 : k]
+				j = k
+			}
+
+			if j < len(src) && src[j] == '}' {
+				start := len(text)
+				text = append(text, defaultValue...)
+				placeholders = append(placeholders, &placeholder{index: index, start: start, end: len(text)})
+				i = j
+				continue
+			}
+		}
+
+		text = append(text, src[i])
+	}
+
+	sort.SliceStable(placeholders, func(i, j int) bool {
+		if placeholders[i].index == 0 || placeholders[j].index == 0 {
+			return placeholders[j].index == 0 && placeholders[i].index != 0
+		}
+		return placeholders[i].index < placeholders[j].index
+	})
+
+	return text, placeholders
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// insertSnippet replaces the read buffer between start and end with the snippet, and selects its first placeholder
+func (r *Reader) insertSnippet(start int, end int, snippet string) {
+	text, placeholders := parseSnippet(snippet)
+
+	b := []rune{}
+	b = append(b, r.readBuffer[:start]...)
+	b = append(b, text...)
+	b = append(b, r.readBuffer[end:]...)
+	r.readBuffer = b
+	r.requireFullRender = true
+
+	r.shiftPlaceholders(start, end, len(text))
+	for _, p := range placeholders {
+		p.start += start
+		p.end += start
+	}
+
+	if len(placeholders) == 0 {
+		r.editOffset = start + len(text)
+		return
+	}
+
+	r.placeholders = placeholders
+	r.placeholderIndex = -1
+	r.nextPlaceholder(1)
+}
+
+// nextPlaceholder moves to the next (or previous, when direction is negative) placeholder and selects it.  Moving beyond
+// the final placeholder ends the snippet.
+func (r *Reader) nextPlaceholder(direction int) {
+	r.requireFullRender = true
+	r.placeholderIndex += direction
+	if r.placeholderIndex < 0 {
+		r.placeholderIndex = 0
+	}
+
+	if r.placeholderIndex >= len(r.placeholders) {
+		r.clearPlaceholders()
+		return
+	}
+
+	p := r.placeholders[r.placeholderIndex]
+	r.editOffset = p.end
+	r.placeholderSelected = p.end > p.start
+
+	if p.index == 0 {
+		// the final cursor position ends the snippet
+		r.clearPlaceholders()
+	}
+}
+
+// activePlaceholder returns the currently selected placeholder, or nil
+func (r *Reader) activePlaceholder() *placeholder {
+	if r.placeholderIndex < 0 || r.placeholderIndex >= len(r.placeholders) {
+		return nil
+	}
+
+	return r.placeholders[r.placeholderIndex]
+}
+
+func (r *Reader) clearPlaceholders() {
+	r.placeholders = nil
+	r.placeholderIndex = -1
+	r.placeholderSelected = false
+}
+
+// shiftPlaceholders adjusts the placeholder ranges after the buffer between cutBegin and cutEnd is replaced with
+// insertLen runes
+func (r *Reader) shiftPlaceholders(cutBegin int, cutEnd int, insertLen int) {
+	delta := insertLen - (cutEnd - cutBegin)
+	active := r.activePlaceholder()
+
+	shift := func(offset int) int {
+		if offset >= cutEnd {
+			return offset + delta
+		}
+		if offset > cutBegin {
+			return cutBegin
+		}
+		return offset
+	}
+
+	for _, p := range r.placeholders {
+		if p == active && cutBegin >= p.start && cutEnd <= p.end {
+			// editing within the active placeholder grows or shrinks it
+			p.end += delta
+			continue
+		}
+		p.start = shift(p.start)
+		p.end = shift(p.end)
+	}
+}
+
+// styledBuffer returns the read buffer with placeholders highlighted
+func (r *Reader) styledBuffer() string {
+	if len(r.placeholders) == 0 {
+		return string(r.readBuffer)
+	}
+
+	active := r.activePlaceholder()
+	styles := make([]string, len(r.readBuffer))
+	for _, p := range r.placeholders {
+		style := termutils.STYLE_UNDERLINE
+		if p == active && r.placeholderSelected {
+			style = termutils.STYLE_REVERSE
+		}
+		for i := p.start; i < p.end && i < len(styles); i++ {
+			styles[i] = style
+		}
+	}
+
+	var sb strings.Builder
+	current := ""
+	for i, ch := range r.readBuffer {
+		if styles[i] != current {
+			sb.WriteString(termutils.STYLE_RESET)
+			sb.WriteString(styles[i])
+			current = styles[i]
+		}
+		sb.WriteRune(ch)
+	}
+	if current != "" {
+		sb.WriteString(termutils.STYLE_RESET)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,49 @@
+package ns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSnippet(t *testing.T) {
+	text, placeholders := parseSnippet("deploy --env ${1:staging} --replicas ${2:3}")
+	assert.Equal(t, "deploy --env staging --replicas 3", string(text))
+	assert.Equal(t, 2, len(placeholders))
+	assert.Equal(t, "staging", string(text[placeholders[0].start:placeholders[0].end]))
+	assert.Equal(t, "3", string(text[placeholders[1].start:placeholders[1].end]))
+}
+
+func TestParseSnippetOrder(t *testing.T) {
+	text, placeholders := parseSnippet("cp $2 $0 ${1} \\$HOME")
+	assert.Equal(t, "cp    $HOME", string(text))
+	assert.Equal(t, 3, len(placeholders))
+	assert.Equal(t, []int{1, 2, 0}, []int{placeholders[0].index, placeholders[1].index, placeholders[2].index})
+	assert.Equal(t, 5, placeholders[0].start)
+	assert.Equal(t, 3, placeholders[1].start)
+}
+
+func TestParseSnippetPlainText(t *testing.T) {
+	assert.False(t, IsSnippet("echo $HOME ${PATH}"))
+	assert.True(t, IsSnippet("echo $1"))
+}
+
+func TestShiftPlaceholders(t *testing.T) {
+	r := NewReader(ReaderConfig{})
+	r.insertSnippet(0, 0, "deploy --env ${1:staging} --replicas ${2:3}")
+	assert.Equal(t, 20, r.editOffset)
+	assert.True(t, r.placeholderSelected)
+
+	r.updateBuffer("p")
+	r.updateBuffer("r")
+	r.updateBuffer("o")
+	r.updateBuffer("d")
+	assert.Equal(t, "deploy --env prod --replicas 3", string(r.readBuffer))
+
+	r.nextPlaceholder(1)
+	r.updateBuffer("5")
+	assert.Equal(t, "deploy --env prod --replicas 5", string(r.readBuffer))
+
+	r.nextPlaceholder(1)
+	assert.Nil(t, r.placeholders)
+}
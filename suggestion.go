@@ -3,6 +3,10 @@ package ns
 type Suggestion struct {
 	Display string
 	Value   string
+	// Preview optionally supplies extended, possibly multi-line, information about the suggestion.  It is displayed below
+	// the suggestion list when the suggestion is selected, either by being the sole completion or by matching the word under
+	// the cursor exactly.
+	Preview func() string
 }
 
 type Suggestions struct {
@@ -23,6 +27,17 @@ func (s *Suggestions) Add(suggestion *Suggestion) {
 	s.Total = len(s.Items)
 }
 
+// Find returns the first suggestion whose value exactly matches the supplied value, or nil
+func (s *Suggestions) Find(value string) *Suggestion {
+	for _, item := range s.Items {
+		if item.Value == value {
+			return item
+		}
+	}
+
+	return nil
+}
+
 func NewSuggestion(display string, value string) *Suggestion {
 	return &Suggestion{
 		Display: display,